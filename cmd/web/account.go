@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// The account page has separate forms for the name, the email address and
+// the password. They share one forms.Form, with differently named fields,
+// so whichever was submitted can be shown again with its errors while the
+// others show the current details.
+func (app *application) renderAccount(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	user := app.authenticatedUser(r)
+
+	if form == nil {
+		form = forms.New(url.Values{})
+	}
+	if _, ok := form.Values["name"]; !ok {
+		form.Set("name", user.Name)
+	}
+	if _, ok := form.Values["email"]; !ok {
+		form.Set("email", user.Email)
+	}
+
+	app.render(w, r, "account.page.tmpl", &templateData{Form: form})
+}
+
+func (app *application) accountForm(w http.ResponseWriter, r *http.Request) {
+	app.renderAccount(w, r, nil)
+}
+
+func (app *application) updateName(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 255)
+	if !form.Valid() {
+		app.renderAccount(w, r, form)
+		return
+	}
+
+	err = app.users.UpdateName(app.authenticatedUser(r).ID, form.Get("name"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your name has been changed.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+func (app *application) updateEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "email_password")
+	form.MatchesPattern("email", forms.EmailRX)
+	if !form.Valid() {
+		app.renderAccount(w, r, form)
+		return
+	}
+
+	// Changing the email address changes where password resets are sent,
+	// so it needs the password too.
+	err = app.users.CheckPassword(user.ID, form.Get("email_password"))
+	if err == models.ErrInvalidCredentials {
+		form.Errors.Add("email_password", "Password is incorrect")
+		app.renderAccount(w, r, form)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if form.Get("email") == user.Email {
+		http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.UpdateEmail(user.ID, form.Get("email"))
+	if err == models.ErrDuplicateEmail {
+		form.Errors.Add("email", "Address is already in use")
+		app.renderAccount(w, r, form)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditEmailChange, UserID: user.ID, Email: form.Get("email")})
+
+	// Links sent to the old address mustn't verify the new one.
+	err = app.tokens.DeleteAllForUser(models.ScopeVerification, user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.sendVerification(r, &models.User{ID: user.ID, Name: user.Name, Email: form.Get("email")})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your email address has been changed. We've emailed you a link to verify it.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+
+	form := forms.New(r.PostForm)
+	form.Required("current_password", "new_password", "confirm_password")
+	form.MinLength("new_password", 10)
+	form.MatchesField("confirm_password", "new_password")
+	if !form.Valid() {
+		app.renderAccount(w, r, form)
+		return
+	}
+
+	err = app.users.CheckPassword(user.ID, form.Get("current_password"))
+	if err == models.ErrInvalidCredentials {
+		form.Errors.Add("current_password", "Password is incorrect")
+		app.renderAccount(w, r, form)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.UpdatePassword(user.ID, form.Get("new_password"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditPasswordChange, UserID: user.ID})
+
+	// Someone who knew the old password may be logged in somewhere else,
+	// so end every session but this one.
+	err = app.sessions.DeleteAllForUser(user.ID, app.currentSession(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.remember.DeleteAllForUser(user.ID, app.currentSession(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your password has been changed, and you've been logged out everywhere else.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
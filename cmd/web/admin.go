@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// The adminTarget helper returns the user with the ID from the URL, for the
+// admin actions on users. Admins can't act on themselves, so they can't
+// lock themselves out by accident.
+func (app *application) adminTarget(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+
+	if id == app.authenticatedUser(r).ID {
+		app.session.Put(r, "flash", "You can't change your own account from the admin area.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return nil, false
+	}
+
+	user, err := app.users.Get(id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return nil, false
+	} else if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// The adminSnippetID helper returns the snippet ID from the URL.
+func (app *application) adminSnippetID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return 0, false
+	}
+	return id, true
+}
+
+// The endUserSessions helper logs a user out everywhere.
+func (app *application) endUserSessions(userID int) error {
+	err := app.sessions.DeleteAllForUser(userID, "")
+	if err != nil {
+		return err
+	}
+	return app.remember.DeleteAllForUser(userID, "")
+}
+
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.stats.System()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "admin.page.tmpl", &templateData{SystemStats: stats})
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	page, ok := pageNumber(r)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+	query := r.URL.Query().Get("q")
+
+	users, err := app.users.Search(query, (page-1)*pageSize, pageSize+1)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td := &templateData{Query: query, Roles: models.Roles, PrevPage: page - 1}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		td.NextPage = page + 1
+	}
+	td.Users = users
+
+	app.render(w, r, "adminusers.page.tmpl", td)
+}
+
+func (app *application) adminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	role := r.PostForm.Get("role")
+	valid := false
+	for _, rl := range models.Roles {
+		valid = valid || rl == role
+	}
+	if !valid {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetRole(user.ID, role)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditRoleChange, UserID: user.ID, Details: user.Role + " to " + role})
+
+	app.session.Put(r, "flash", fmt.Sprintf("%s is now a %s.", user.Name, role))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminDisableUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	disabled := r.PostForm.Get("disabled") == "true"
+
+	err = app.users.SetDisabled(user.ID, disabled)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if disabled {
+		app.audit(r, &models.AuditEvent{Action: models.AuditUserDisable, UserID: user.ID})
+	} else {
+		app.audit(r, &models.AuditEvent{Action: models.AuditUserEnable, UserID: user.ID})
+	}
+
+	if disabled {
+		err = app.endUserSessions(user.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "flash", fmt.Sprintf("%s has been disabled and logged out.", user.Name))
+	} else {
+		app.session.Put(r, "flash", fmt.Sprintf("%s has been enabled.", user.Name))
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.endUserSessions(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.Delete(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditUserDelete, UserID: user.ID, Email: user.Email})
+
+	app.session.Put(r, "flash", fmt.Sprintf("%s and everything they created has been deleted.", user.Name))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	page, ok := pageNumber(r)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+	query := r.URL.Query().Get("q")
+
+	snippets, err := app.snippets.Search(query, (page-1)*pageSize, pageSize+1, app.moderatorViewer(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td := &templateData{Query: query, PrevPage: page - 1}
+	if len(snippets) > pageSize {
+		snippets = snippets[:pageSize]
+		td.NextPage = page + 1
+	}
+	td.Snippets = snippets
+
+	app.render(w, r, "adminsnippets.page.tmpl", td)
+}
+
+func (app *application) adminHideSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminSnippetID(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	hidden := r.PostForm.Get("hidden") == "true"
+
+	err = app.snippets.SetHidden(id, hidden, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.snippetEventByID(models.EventSnippetUpdated, id)
+
+	if hidden {
+		app.audit(r, &models.AuditEvent{Action: models.AuditSnippetHide, SnippetID: id})
+		app.session.Put(r, "flash", fmt.Sprintf("Snippet %d has been hidden.", id))
+	} else {
+		app.audit(r, &models.AuditEvent{Action: models.AuditSnippetUnhide, SnippetID: id})
+		app.session.Put(r, "flash", fmt.Sprintf("Snippet %d is visible again.", id))
+	}
+	http.Redirect(w, r, adminReturnURL(r, "/admin/snippets"), http.StatusSeeOther)
+}
+
+func (app *application) adminDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminSnippetID(w, r)
+	if !ok {
+		return
+	}
+
+	// Keep a copy of the snippet to tell its author's webhooks about. It
+	// may have expired, in which case they've already been told.
+	s, err := app.snippets.Get(id, app.moderatorViewer(r))
+	if err != nil && err != models.ErrNoRecord {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.snippets.Delete(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditSnippetDelete, SnippetID: id})
+	if s != nil {
+		app.snippetEvent(models.EventSnippetDeleted, s)
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("Snippet %d has been deleted.", id))
+	http.Redirect(w, r, adminReturnURL(r, "/admin/snippets"), http.StatusSeeOther)
+}
+
+// The adminReturnURL helper returns the page to go back to after an admin
+// action, keeping the search the action was taken from.
+func adminReturnURL(r *http.Request, path string) string {
+	if q := r.PostForm.Get("q"); q != "" {
+		return path + "?" + url.Values{"q": {q}}.Encode()
+	}
+	return path
+}
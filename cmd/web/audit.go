@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// The audit helper records an event in the audit log, along with the
+// request's IP address and user agent, and the logged in user if the event
+// doesn't say who did it. A failure to record an event is logged rather
+// than failing the request it describes.
+func (app *application) audit(r *http.Request, e *models.AuditEvent) {
+	if e.ActorID == 0 {
+		if user := app.authenticatedUser(r); user != nil {
+			e.ActorID = user.ID
+		}
+	}
+	e.IP = remoteIP(r)
+	e.UserAgent = r.UserAgent()
+	if len(e.UserAgent) > 255 {
+		e.UserAgent = e.UserAgent[:255]
+	}
+
+	err := app.auditLog.Insert(e)
+	if err != nil {
+		app.errorLog.Printf("audit %s: %s", e.Action, err)
+	}
+}
+
+// parseAuditFilter reads the audit log filter from query string values,
+// adding an error to the form for each one which is invalid. Dates are
+// days in UTC, and the until day is included.
+func parseAuditFilter(form *forms.Form) models.AuditFilter {
+	f := models.AuditFilter{Action: form.Get("action"), IP: form.Get("ip")}
+
+	if f.Action != "" {
+		form.PermittedValues("action", models.AuditActions...)
+	}
+	if v := form.Get("user"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			form.Errors.Add("user", "This field must be a user ID")
+		}
+		f.UserID = id
+	}
+	if v := form.Get("since"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			form.Errors.Add("since", "This field must be a date")
+		}
+		f.Since = t
+	}
+	if v := form.Get("until"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			form.Errors.Add("until", "This field must be a date")
+		}
+		f.Until = t.AddDate(0, 0, 1)
+	}
+	return f
+}
+
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	page, ok := pageNumber(r)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	form := forms.New(r.URL.Query())
+	filter := parseAuditFilter(form)
+	td := &templateData{AuditActions: models.AuditActions, Form: form, PrevPage: page - 1}
+	if !form.Valid() {
+		app.render(w, r, "audit.page.tmpl", td)
+		return
+	}
+
+	events, err := app.auditLog.List(filter, (page-1)*pageSize, pageSize+1)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		td.NextPage = page + 1
+	}
+	td.AuditEvents = events
+
+	app.render(w, r, "audit.page.tmpl", td)
+}
+
+// auditRecord is an audit log entry as it's exported, with everything
+// needed to check the hash chain outside the application.
+type auditRecord struct {
+	ID        int       `json:"id"`
+	Created   time.Time `json:"created"`
+	Action    string    `json:"action"`
+	ActorID   int       `json:"actor_id,omitempty"`
+	UserID    int       `json:"user_id,omitempty"`
+	SnippetID int       `json:"snippet_id,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Redacted  bool      `json:"redacted,omitempty"`
+	PIIDigest string    `json:"pii_digest"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// adminExportAudit writes the entries matching the filter as JSON lines,
+// oldest first.
+func (app *application) adminExportAudit(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	filter := parseAuditFilter(form)
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, time.Now().UTC().Format("20060102-150405")))
+
+	// Once the first line is written it's too late for an error page, so
+	// a failure part way through can only be logged.
+	enc := json.NewEncoder(w)
+	err := app.auditLog.Each(filter, func(e *models.AuditEvent) error {
+		return enc.Encode(auditRecord(*e))
+	})
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+func (app *application) adminVerifyAudit(w http.ResponseWriter, r *http.Request) {
+	checked, broken, err := app.auditLog.Verify()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if broken != 0 {
+		app.errorLog.Printf("audit log hash chain is broken at entry %d", broken)
+		app.session.Put(r, "flash", fmt.Sprintf("The audit log has been tampered with: entry #%d doesn't match the ones before it.", broken))
+	} else {
+		app.session.Put(r, "flash", fmt.Sprintf("The audit log is intact (%d entries checked).", checked))
+	}
+	http.Redirect(w, r, "/admin/audit", http.StatusSeeOther)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+func TestParseAuditFilter(t *testing.T) {
+	form := forms.New(url.Values{
+		"action": {models.AuditLoginFailed},
+		"user":   {"7"},
+		"ip":     {"192.0.2.1"},
+		"since":  {"2024-03-01"},
+		"until":  {"2024-03-02"},
+	})
+	f := parseAuditFilter(form)
+	if !form.Valid() {
+		t.Fatalf("want valid filter; got errors %v", form.Errors)
+	}
+
+	want := models.AuditFilter{
+		Action: models.AuditLoginFailed,
+		UserID: 7,
+		IP:     "192.0.2.1",
+		Since:  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Until:  time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC),
+	}
+	if f != want {
+		t.Errorf("want %+v; got %+v", want, f)
+	}
+
+	tests := []struct {
+		name  string
+		field string
+		value string
+	}{
+		{"Unknown action", "action", "user.teleport"},
+		{"User not a number", "user", "bob"},
+		{"Bad since date", "since", "yesterday"},
+		{"Bad until date", "until", "2024-13-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := forms.New(url.Values{tt.field: {tt.value}})
+			parseAuditFilter(form)
+			if form.Errors.Get(tt.field) == "" {
+				t.Errorf("want error for %s %q; got none", tt.field, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backups made by "web backup" are gzipped JSON lines, which don't depend
+// on the database they came from:
+//
+//	{"format":"snippetbox.backup","version":1,"created":"...","tables":["users",...]}
+//	{"table":"users","columns":[{"name":"id","type":"int"},...]}
+//	[1,"Alice",...]
+//	{"end":"users","rows":1,"sha256":"..."}
+//	...
+//	{"done":true}
+//
+// Each table's rows are followed by their count and the SHA-256 of the
+// lines holding them, so a damaged or cut short backup is noticed before a
+// restore is committed. The version goes up whenever the format changes in
+// a way which older versions couldn't read.
+const (
+	backupFormat  = "snippetbox.backup"
+	backupVersion = 1
+)
+
+// backupTables are the tables which are backed up, with tables before
+// the ones which refer to them. Sessions and counts of failed logins are
+// short-lived, and left out.
+var backupTables = []string{
+	"users", "orgs", "org_members", "org_invitations", "snippets",
+	"snippet_access", "share_links", "comments", "stars", "reports",
+	"snippet_views", "snippet_visitors", "tokens", "remember_tokens",
+	"recovery_codes", "webauthn_credentials", "user_identities", "webhooks",
+	"webhook_deliveries", "audit_log",
+}
+
+// The portable types of backed up columns, and how their values are
+// written: ints and floats as JSON numbers, text as strings, bytes as
+// base64 strings and times as RFC 3339 strings in UTC.
+const (
+	columnInt   = "int"
+	columnFloat = "float"
+	columnText  = "text"
+	columnBytes = "bytes"
+	columnTime  = "time"
+)
+
+type backupColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type backupHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Tables  []string  `json:"tables"`
+}
+
+// backupMarker is any of the lines which aren't the header or a row: the
+// start or end of a table, or the end of the backup.
+type backupMarker struct {
+	Table   string         `json:"table,omitempty"`
+	Columns []backupColumn `json:"columns,omitempty"`
+	End     string         `json:"end,omitempty"`
+	Rows    int64          `json:"rows,omitempty"`
+	SHA256  string         `json:"sha256,omitempty"`
+	Done    bool           `json:"done,omitempty"`
+}
+
+// columnType returns the portable type for a MySQL column type, as named
+// by the driver. Decimals are kept as text so that they stay exact.
+func columnType(dbType string) string {
+	switch t := strings.TrimPrefix(dbType, "UNSIGNED "); {
+	case strings.HasSuffix(t, "INT"), t == "YEAR":
+		return columnInt
+	case t == "FLOAT", t == "DOUBLE":
+		return columnFloat
+	case t == "DATETIME", t == "DATE", t == "TIMESTAMP":
+		return columnTime
+	case strings.HasSuffix(t, "BLOB"), strings.HasSuffix(t, "BINARY"), t == "BIT", t == "GEOMETRY":
+		return columnBytes
+	default:
+		return columnText
+	}
+}
+
+// encodeBackupValue turns a value scanned from the database into what's
+// written for it.
+func encodeBackupValue(col backupColumn, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch col.Type {
+	case columnInt, columnFloat:
+		switch v := v.(type) {
+		case int64:
+			return json.Number(strconv.FormatInt(v, 10)), nil
+		case uint64:
+			return json.Number(strconv.FormatUint(v, 10)), nil
+		case float64:
+			return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+		case []byte:
+			return json.Number(v), nil
+		}
+	case columnTime:
+		if t, ok := v.(time.Time); ok {
+			return t.UTC().Format(time.RFC3339Nano), nil
+		}
+		return nil, fmt.Errorf("column %s isn't a time: add parseTime=true to the DSN", col.Name)
+	case columnBytes:
+		if b, ok := v.([]byte); ok {
+			return base64.StdEncoding.EncodeToString(b), nil
+		}
+	case columnText:
+		switch v := v.(type) {
+		case []byte:
+			return string(v), nil
+		case string:
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %T in %s column %s", v, col.Type, col.Name)
+}
+
+// decodeBackupValue turns a value read from a backup, decoded with
+// UseNumber, back into one for the database.
+func decodeBackupValue(col backupColumn, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch col.Type {
+	case columnInt:
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+			if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+				return u, nil
+			}
+		}
+	case columnFloat:
+		if n, ok := v.(json.Number); ok {
+			if f, err := n.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	case columnTime:
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t, nil
+			}
+		}
+	case columnBytes:
+		if s, ok := v.(string); ok {
+			if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+				return b, nil
+			}
+		}
+	case columnText:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	default:
+		return nil, fmt.Errorf("column %s has unknown type %q", col.Name, col.Type)
+	}
+	return nil, fmt.Errorf("bad %s value for column %s", col.Type, col.Name)
+}
+
+// backupWriter writes a backup, one line at a time.
+type backupWriter struct {
+	buf   *bufio.Writer
+	gz    *gzip.Writer
+	table string
+	cols  []backupColumn
+	rows  int64
+	hash  hash.Hash
+}
+
+func newBackupWriter(w io.Writer, tables []string) (*backupWriter, error) {
+	gz := gzip.NewWriter(w)
+	bw := &backupWriter{buf: bufio.NewWriter(gz), gz: gz}
+	return bw, bw.line(&backupHeader{Format: backupFormat, Version: backupVersion, Created: time.Now().UTC(), Tables: tables})
+}
+
+func (bw *backupWriter) line(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if bw.hash != nil {
+		bw.hash.Write(b)
+		bw.hash.Write([]byte("\n"))
+	}
+	if _, err = bw.buf.Write(b); err != nil {
+		return err
+	}
+	return bw.buf.WriteByte('\n')
+}
+
+// StartTable begins the rows of a table with the given columns.
+func (bw *backupWriter) StartTable(name string, cols []backupColumn) error {
+	bw.table, bw.cols, bw.rows, bw.hash = name, cols, 0, nil
+	if err := bw.line(&backupMarker{Table: name, Columns: cols}); err != nil {
+		return err
+	}
+	bw.hash = sha256.New()
+	return nil
+}
+
+// Row writes a row of the current table, as scanned from the database.
+func (bw *backupWriter) Row(values []interface{}) error {
+	row := make([]interface{}, len(values))
+	for i, v := range values {
+		var err error
+		row[i], err = encodeBackupValue(bw.cols[i], v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", bw.table, err)
+		}
+	}
+	bw.rows++
+	return bw.line(row)
+}
+
+// EndTable finishes the current table, returning how many rows it had.
+func (bw *backupWriter) EndTable() (int64, error) {
+	sum := hex.EncodeToString(bw.hash.Sum(nil))
+	bw.hash = nil
+	return bw.rows, bw.line(&backupMarker{End: bw.table, Rows: bw.rows, SHA256: sum})
+}
+
+// Close marks the end of the backup and flushes it.
+func (bw *backupWriter) Close() error {
+	if err := bw.line(&backupMarker{Done: true}); err != nil {
+		return err
+	}
+	if err := bw.buf.Flush(); err != nil {
+		return err
+	}
+	return bw.gz.Close()
+}
+
+// A backupSink is given the contents of a backup as it's read. Rows are
+// handed over before their table's checksum has been checked, so a sink
+// has to be able to undo them if readBackup fails.
+type backupSink interface {
+	Begin(h *backupHeader) error
+	StartTable(name string, cols []backupColumn) error
+	Row(values []interface{}) error
+	EndTable(name string, rows int64) error
+}
+
+// readBackup reads a backup, gzipped or not, checking it as it goes and
+// passing its contents to the sink. An error is returned if anything is
+// wrong with it, including the checksums not matching or it being cut
+// short.
+func readBackup(r io.Reader, sink backupSink) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	dec := json.NewDecoder(r)
+	var h backupHeader
+	if err := dec.Decode(&h); err != nil || h.Format != backupFormat {
+		return errors.New("not a Snippetbox backup")
+	}
+	if h.Version > backupVersion {
+		return fmt.Errorf("the backup is version %d, and only version %d and older can be restored", h.Version, backupVersion)
+	}
+	if err := sink.Begin(&h); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	var table string
+	var cols []backupColumn
+	var rows int64
+	var sum hash.Hash
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return errors.New("the backup is incomplete")
+		} else if err != nil {
+			return fmt.Errorf("the backup is damaged: %w", err)
+		}
+
+		if raw[0] == '[' {
+			if table == "" {
+				return errors.New("the backup has a row outside a table")
+			}
+			sum.Write(raw)
+			sum.Write([]byte("\n"))
+			rows++
+
+			rd := json.NewDecoder(bytes.NewReader(raw))
+			rd.UseNumber()
+			var row []interface{}
+			if err := rd.Decode(&row); err != nil {
+				return fmt.Errorf("%s row %d: %w", table, rows, err)
+			}
+			if len(row) != len(cols) {
+				return fmt.Errorf("%s row %d has %d columns, not %d", table, rows, len(row), len(cols))
+			}
+			for i, v := range row {
+				var err error
+				if row[i], err = decodeBackupValue(cols[i], v); err != nil {
+					return fmt.Errorf("%s row %d: %w", table, rows, err)
+				}
+			}
+			if err := sink.Row(row); err != nil {
+				return fmt.Errorf("%s row %d: %w", table, rows, err)
+			}
+			continue
+		}
+
+		var m backupMarker
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return fmt.Errorf("the backup is damaged: %w", err)
+		}
+		switch {
+		case m.Table != "":
+			if table != "" || seen[m.Table] || !contains(h.Tables, m.Table) || len(m.Columns) == 0 {
+				return fmt.Errorf("the backup has an unexpected table %q", m.Table)
+			}
+			table, cols, rows, sum = m.Table, m.Columns, 0, sha256.New()
+			seen[table] = true
+			if err := sink.StartTable(table, cols); err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+		case m.End != "":
+			if m.End != table {
+				return fmt.Errorf("the backup has an unexpected end of table %q", m.End)
+			}
+			if m.Rows != rows || m.SHA256 != hex.EncodeToString(sum.Sum(nil)) {
+				return fmt.Errorf("%s doesn't match its checksum", table)
+			}
+			if err := sink.EndTable(table, rows); err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			table = ""
+		case m.Done:
+			if table != "" || len(seen) != len(h.Tables) {
+				return errors.New("the backup is incomplete")
+			}
+			if dec.More() {
+				return errors.New("the backup has data after its end")
+			}
+			return nil
+		default:
+			return errors.New("the backup has an unexpected line")
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordSink keeps what's read from a backup.
+type recordSink struct {
+	tables []string
+	rows   [][]interface{}
+}
+
+func (s *recordSink) Begin(h *backupHeader) error { return nil }
+
+func (s *recordSink) StartTable(name string, cols []backupColumn) error {
+	s.tables = append(s.tables, name)
+	return nil
+}
+
+func (s *recordSink) Row(values []interface{}) error {
+	s.rows = append(s.rows, values)
+	return nil
+}
+
+func (s *recordSink) EndTable(name string, rows int64) error { return nil }
+
+// testBackup returns a backup of a users and a snippets table, unzipped.
+func testBackup(t *testing.T) []byte {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	bw, err := newBackupWriter(&buf, []string{"users", "snippets"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bw.StartTable("users", []backupColumn{{"id", columnInt}, {"name", columnText}, {"created", columnTime}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = bw.Row([]interface{}{int64(1), []byte("Alice"), created}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bw.EndTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = bw.StartTable("snippets", []backupColumn{{"id", columnInt}, {"user_id", columnInt}, {"content", columnBytes}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = bw.Row([]interface{}{[]byte("7"), nil, []byte{0, 1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bw.EndTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadBackup(t *testing.T) {
+	data := testBackup(t)
+
+	sink := &recordSink{}
+	if err := readBackup(bytes.NewReader(data), sink); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]interface{}{
+		{int64(1), "Alice", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{int64(7), nil, []byte{0, 1, 2}},
+	}
+	if !reflect.DeepEqual(sink.tables, []string{"users", "snippets"}) || !reflect.DeepEqual(sink.rows, want) {
+		t.Errorf("want %v; got %v, %v", want, sink.tables, sink.rows)
+	}
+
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(data)
+	zw.Close()
+	if err := readBackup(&zipped, &recordSink{}); err != nil {
+		t.Errorf("gzipped: %s", err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"Changed row", strings.Replace(string(data), "Alice", "Mallory", 1)},
+		{"Missing row", strings.Join(append(lines[:2:2], lines[3:]...), "")},
+		{"Missing table", strings.Join(append(lines[:4:4], lines[7:]...), "")},
+		{"Cut short", strings.Join(lines[:6], "")},
+		{"Newer version", strings.Replace(string(data), `"version":1`, `"version":2`, 1)},
+		{"Not a backup", `{"hello":"world"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := readBackup(strings.NewReader(tt.data), &recordSink{}); err == nil {
+				t.Error("want an error; got none")
+			}
+		})
+	}
+}
+
+func TestColumnType(t *testing.T) {
+	for dbType, want := range map[string]string{
+		"INT":          columnInt,
+		"UNSIGNED INT": columnInt,
+		"TINYINT":      columnInt,
+		"DOUBLE":       columnFloat,
+		"DATETIME":     columnTime,
+		"DATE":         columnTime,
+		"VARBINARY":    columnBytes,
+		"BLOB":         columnBytes,
+		"VARCHAR":      columnText,
+		"MEDIUMTEXT":   columnText,
+		"DECIMAL":      columnText,
+	} {
+		if got := columnType(dbType); got != want {
+			t.Errorf("%s: want %s; got %s", dbType, want, got)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+	"github.com/ardianeffendi/snippetbox/pkg/models/mysql"
+)
+
+// Besides running the server, the web binary has subcommands for looking
+// after the application, run like:
+//
+//	web create-admin -email alice@example.com
+//	web backup -o snippetbox.backup.gz
+//	web restore -dsn 'web:pass@tcp(new-host)/snippetbox?parseTime=true' snippetbox.backup.gz
+//
+// Each subcommand has its own flags, including -dsn where it needs the
+// database.
+
+// defaultDSN is the default for every -dsn flag.
+const defaultDSN = "web:tokyodome@/snippetbox?parseTime=true"
+
+// A command is a subcommand of the web binary. It's given its arguments
+// and where to write its output.
+type command struct {
+	usage string
+	run   func(args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"backup":       {"Write the database to a portable backup file", backupCommand},
+	"create-admin": {"Give an existing user the admin role", createAdminCommand},
+	"restore":      {"Restore a backup file into an empty database", restoreCommand},
+}
+
+// errUsage is returned by commands whose arguments are wrong, once they
+// have explained why.
+var errUsage = errors.New("usage")
+
+// The runCommand function runs the named subcommand and returns the exit
+// status for the process.
+func runCommand(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\nCommands:\n", name)
+		names := make([]string, 0, len(commands))
+		for n := range commands {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(os.Stderr, "  %-14s %s\n", n, commands[n].usage)
+		}
+		return 2
+	}
+
+	err := cmd.run(args, os.Stdout)
+	if err == errUsage || err == flag.ErrHelp {
+		return 2
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// The createAdminCommand bootstraps the first admin. The user has to have
+// signed up already; they're given the admin role and marked verified.
+func createAdminCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	dsn := fs.String("dsn", defaultDSN, "MySQL data source name")
+	email := fs.String("email", "", "Email address of the user to make an admin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" {
+		fmt.Fprintln(fs.Output(), "-email is required")
+		fs.Usage()
+		return errUsage
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	users := &mysql.UserModel{DB: db}
+	user, err := users.GetByEmail(*email)
+	if err == models.ErrNoRecord {
+		return fmt.Errorf("there is no user with the email address %s: sign up first", *email)
+	} else if err != nil {
+		return err
+	}
+
+	if err = users.SetRole(user.ID, models.RoleAdmin); err != nil {
+		return err
+	}
+	if err = users.SetVerified(user.ID); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s (%s) is now an admin\n", user.Name, user.Email)
+	return nil
+}
+
+// The backupCommand writes every table in backupTables which exists to a
+// backup file, or to standard output with -o -. The rows are read in one
+// transaction, so they're consistent with each other, and streamed
+// straight to the file.
+func backupCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	dsn := fs.String("dsn", defaultDSN, "MySQL data source name")
+	out := fs.String("o", "snippetbox-"+time.Now().UTC().Format("20060102-150405")+".backup.gz", "File to write the backup to, or - for standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Tables for features which have never been set up are skipped.
+	var tables []string
+	for _, t := range backupTables {
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM information_schema.tables
+            WHERE table_schema = DATABASE() AND table_name = ?)`, t).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			tables = append(tables, t)
+		}
+	}
+
+	// The summary goes to standard error if the backup itself is going to
+	// standard output.
+	w, log := io.Writer(os.Stdout), stdout
+	if *out == "-" {
+		log = os.Stderr
+	} else {
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	bw, err := newBackupWriter(w, tables)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		n, err := backupTable(tx, bw, t)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(log, "%-20s %d rows\n", t, n)
+	}
+	if err = bw.Close(); err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		if err = f.Sync(); err != nil {
+			return err
+		}
+		fmt.Fprintf(log, "Backed up to %s\n", *out)
+	}
+	return nil
+}
+
+// backupTable streams the rows of a table to the backup.
+func backupTable(tx *sql.Tx, bw *backupWriter, table string) (int64, error) {
+	rows, err := tx.Query("SELECT * FROM " + table)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, err
+	}
+	cols := make([]backupColumn, len(types))
+	for i, ct := range types {
+		cols[i] = backupColumn{Name: ct.Name(), Type: columnType(ct.DatabaseTypeName())}
+	}
+	if err = bw.StartTable(table, cols); err != nil {
+		return 0, err
+	}
+
+	values := make([]interface{}, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+		if err = bw.Row(values); err != nil {
+			return 0, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return bw.EndTable()
+}
+
+// The restoreCommand restores a backup file into a database which already
+// has the tables, but no rows in them unless -replace is given. It's all
+// done in one transaction, which is only committed once every checksum
+// has matched. With -check the backup is only read and checked.
+func restoreCommand(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dsn := fs.String("dsn", defaultDSN, "MySQL data source name")
+	replace := fs.Bool("replace", false, "Delete the rows already in the tables first")
+	check := fs.Bool("check", false, "Only check the backup, without restoring it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: web restore [flags] FILE")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if *check {
+		sink := &checkSink{w: stdout}
+		if err = readBackup(f, sink); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "The backup from %s is intact\n", humanDate(sink.created))
+		return nil
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// The session settings have to stay on one connection.
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sink := &mysqlSink{conn: conn, replace: *replace, w: stdout}
+	err = readBackup(f, sink)
+	if sink.tx != nil {
+		if err == nil {
+			err = sink.tx.Commit()
+		} else {
+			sink.tx.Rollback()
+		}
+	}
+	conn.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(stdout, "Restored")
+	return nil
+}
+
+// checkSink reads a backup without doing anything with it, for restore
+// -check.
+type checkSink struct {
+	w       io.Writer
+	created time.Time
+}
+
+func (s *checkSink) Begin(h *backupHeader) error {
+	s.created = h.Created
+	return nil
+}
+
+func (s *checkSink) StartTable(name string, cols []backupColumn) error { return nil }
+
+func (s *checkSink) Row(values []interface{}) error { return nil }
+
+func (s *checkSink) EndTable(name string, rows int64) error {
+	fmt.Fprintf(s.w, "%-20s %d rows\n", name, rows)
+	return nil
+}
+
+// columnNameRX matches the column names a restore will use. Table names
+// have to be in backupTables, so nothing from the file can change the
+// statements beyond which columns they fill.
+var columnNameRX = regexp.MustCompile("^[a-z][a-z0-9_]*$")
+
+// mysqlSink restores a backup into MySQL, in a transaction which the
+// caller commits once the whole backup has been read.
+type mysqlSink struct {
+	conn    *sql.Conn
+	replace bool
+	w       io.Writer
+	tx      *sql.Tx
+	insert  *sql.Stmt
+}
+
+func (s *mysqlSink) Begin(h *backupHeader) error {
+	for _, t := range h.Tables {
+		if !contains(backupTables, t) {
+			return fmt.Errorf("the backup has an unknown table %q", t)
+		}
+	}
+
+	// Rows are restored table by table, and some tables refer to their
+	// own rows, so foreign keys can't be checked until they're all in.
+	ctx := context.Background()
+	if _, err := s.conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return err
+	}
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+
+	for _, t := range h.Tables {
+		if s.replace {
+			if _, err = tx.Exec("DELETE FROM " + t); err != nil {
+				return fmt.Errorf("%s: %w", t, err)
+			}
+			continue
+		}
+		var exists bool
+		if err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM " + t + ")").Scan(&exists); err != nil {
+			return fmt.Errorf("%s: %w", t, err)
+		}
+		if exists {
+			return fmt.Errorf("%s already has rows in it: restore into an empty database, or use -replace", t)
+		}
+	}
+	return nil
+}
+
+func (s *mysqlSink) StartTable(name string, cols []backupColumn) error {
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		if !columnNameRX.MatchString(col.Name) {
+			return fmt.Errorf("bad column name %q", col.Name)
+		}
+		names[i] = "`" + col.Name + "`"
+	}
+
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", name, strings.Join(names, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+	var err error
+	s.insert, err = s.tx.Prepare(stmt)
+	return err
+}
+
+func (s *mysqlSink) Row(values []interface{}) error {
+	_, err := s.insert.Exec(values...)
+	return err
+}
+
+func (s *mysqlSink) EndTable(name string, rows int64) error {
+	fmt.Fprintf(s.w, "%-20s %d rows\n", name, rows)
+	return s.insert.Close()
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// exportFormat and exportVersion identify the snippet archives made by
+// /user/export. The version goes up whenever the format changes in a way
+// which older versions of the importer couldn't read.
+const (
+	exportFormat  = "snippetbox.snippets"
+	exportVersion = 1
+)
+
+// archiveManifest is the name of the JSON archive inside a zip export.
+// The zip also holds a plain text copy of each snippet, which is there to
+// be read rather than imported.
+const archiveManifest = "snippets.json"
+
+// Visibilities of an archived snippet.
+const (
+	visibilityPublic  = "public"
+	visibilityPrivate = "private"
+	visibilityOrg     = "org"
+)
+
+// snippetArchive is a user's snippets as exported, and as read back in by
+// the importer.
+type snippetArchive struct {
+	Format   string             `json:"format"`
+	Version  int                `json:"version"`
+	Exported time.Time          `json:"exported"`
+	Snippets []*archivedSnippet `json:"snippets"`
+}
+
+// archivedSnippet is a snippet in an archive. Org is the slug of the
+// organisation the snippet belongs to, for the org visibility.
+type archivedSnippet struct {
+	ID         int       `json:"id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Created    time.Time `json:"created"`
+	Expires    time.Time `json:"expires"`
+	Visibility string    `json:"visibility"`
+	Org        string    `json:"org,omitempty"`
+	ParentID   int       `json:"parent_id,omitempty"`
+}
+
+// gist is the part of a GitHub gist, as returned by its API, which can be
+// imported. Each of its files becomes a snippet.
+type gist struct {
+	Description string    `json:"description"`
+	Public      *bool     `json:"public"`
+	CreatedAt   time.Time `json:"created_at"`
+	Files       map[string]struct {
+		Filename string `json:"filename"`
+		Content  string `json:"content"`
+	} `json:"files"`
+}
+
+// The exportSnippets handler sends the user all their live snippets, as a
+// JSON archive or, with ?format=zip, a zip file holding one.
+func (app *application) exportSnippets(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		app.notFound(w)
+		return
+	}
+
+	a := &snippetArchive{Format: exportFormat, Version: exportVersion, Exported: time.Now().UTC(), Snippets: []*archivedSnippet{}}
+	slugs := map[int]string{}
+	err := app.snippets.EachByUser(app.authenticatedUser(r).ID, func(s *models.Snippet) error {
+		as := &archivedSnippet{
+			ID:         s.ID,
+			Title:      s.Title,
+			Content:    s.Content,
+			Created:    s.Created.UTC(),
+			Expires:    s.Expires.UTC(),
+			Visibility: visibilityPublic,
+			ParentID:   s.ParentID,
+		}
+		switch {
+		case s.OrgID != 0:
+			if _, ok := slugs[s.OrgID]; !ok {
+				org, err := app.orgs.Get(s.OrgID)
+				if err != nil {
+					return err
+				}
+				slugs[s.OrgID] = org.Slug
+			}
+			as.Visibility = visibilityOrg
+			as.Org = slugs[s.OrgID]
+		case s.Private:
+			as.Visibility = visibilityPrivate
+		}
+		a.Snippets = append(a.Snippets, as)
+		return nil
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	name := "snippetbox-" + a.Exported.Format("2006-01-02")
+	if format == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+		err = writeArchiveZip(w, a)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+		err = writeArchiveJSON(w, a)
+	}
+	if err != nil {
+		// The headers have gone by now, so all that can be done is to log
+		// it; the download will be cut short.
+		app.errorLog.Print(err)
+	}
+}
+
+func writeArchiveJSON(w io.Writer, a *snippetArchive) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
+
+func writeArchiveZip(w io.Writer, a *snippetArchive) error {
+	zw := zip.NewWriter(w)
+	f, err := zw.Create(archiveManifest)
+	if err != nil {
+		return err
+	}
+	if err = writeArchiveJSON(f, a); err != nil {
+		return err
+	}
+
+	for _, s := range a.Snippets {
+		f, err := zw.Create(fmt.Sprintf("files/%d-%s.txt", s.ID, fileSlug(s.Title)))
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(f, s.Content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// fileSlug turns a snippet title into something safe to use in a file
+// name.
+func fileSlug(title string) string {
+	slug := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, title)
+	slug = strings.Trim(slug, "-")
+	for strings.Contains(slug, "--") {
+		slug = strings.ReplaceAll(slug, "--", "-")
+	}
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+	if slug == "" {
+		return "snippet"
+	}
+	return slug
+}
+
+// readArchive reads the snippets from an uploaded file, which may be a
+// JSON or zip export from /user/export, or a gist (or an array of them)
+// in the JSON returned by GitHub's API. Each file of a gist becomes a
+// snippet titled after the gist's description, or its file name if it
+// has none.
+func readArchive(data []byte) ([]*archivedSnippet, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		var err error
+		data, err = readZipManifest(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var gists []*gist
+		if err := json.Unmarshal(data, &gists); err != nil {
+			return nil, errors.New("the file isn't valid JSON")
+		}
+		var snippets []*archivedSnippet
+		for _, g := range gists {
+			snippets = append(snippets, g.snippets()...)
+		}
+		return snippets, nil
+	}
+
+	var probe struct {
+		Format  string          `json:"format"`
+		Version int             `json:"version"`
+		Files   json.RawMessage `json:"files"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, errors.New("the file isn't a JSON or zip file")
+	}
+
+	switch {
+	case probe.Format == exportFormat:
+		if probe.Version > exportVersion {
+			return nil, errors.New("the export was made by a newer version of Snippetbox")
+		}
+		var a snippetArchive
+		if err := json.Unmarshal(data, &a); err != nil {
+			return nil, errors.New("the export is damaged")
+		}
+		return a.Snippets, nil
+	case probe.Files != nil:
+		var g gist
+		if err := json.Unmarshal(data, &g); err != nil {
+			return nil, errors.New("the gist is damaged")
+		}
+		return g.snippets(), nil
+	default:
+		return nil, errors.New("the file isn't a Snippetbox export or a gist")
+	}
+}
+
+// readZipManifest returns the JSON archive inside a zip export.
+func readZipManifest(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("the zip file is damaged")
+	}
+	for _, f := range zr.File {
+		if f.Name != archiveManifest {
+			continue
+		}
+		if f.UncompressedSize64 > maxImportSize {
+			return nil, errors.New("the export is too large")
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.New("the zip file is damaged")
+		}
+		defer rc.Close()
+		manifest, err := io.ReadAll(io.LimitReader(rc, maxImportSize+1))
+		if err != nil || len(manifest) > maxImportSize {
+			return nil, errors.New("the zip file is damaged")
+		}
+		return manifest, nil
+	}
+	return nil, fmt.Errorf("the zip file has no %s in it", archiveManifest)
+}
+
+func (g *gist) snippets() []*archivedSnippet {
+	names := make([]string, 0, len(g.Files))
+	for name := range g.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	visibility := visibilityPublic
+	if g.Public != nil && !*g.Public {
+		visibility = visibilityPrivate
+	}
+
+	var snippets []*archivedSnippet
+	for _, name := range names {
+		f := g.Files[name]
+		if f.Filename == "" {
+			f.Filename = name
+		}
+		title := f.Filename
+		if g.Description != "" {
+			title = g.Description
+			if len(g.Files) > 1 {
+				title += " (" + f.Filename + ")"
+			}
+		}
+		snippets = append(snippets, &archivedSnippet{
+			Title:      title,
+			Content:    f.Content,
+			Created:    g.CreatedAt,
+			Visibility: visibility,
+		})
+	}
+	return snippets
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+func TestReadArchive(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := &snippetArchive{
+		Format:   exportFormat,
+		Version:  exportVersion,
+		Exported: created,
+		Snippets: []*archivedSnippet{
+			{ID: 1, Title: "Hello", Content: "world", Created: created, Expires: created.Add(time.Hour), Visibility: visibilityPublic},
+			{ID: 2, Title: "Team", Content: "notes", Created: created, Expires: created.Add(time.Hour), Visibility: visibilityOrg, Org: "acme"},
+		},
+	}
+
+	var js, zipped bytes.Buffer
+	if err := writeArchiveJSON(&js, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeArchiveZip(&zipped, a); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"JSON": js.Bytes(), "Zip": zipped.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			got, err := readArchive(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, a.Snippets) {
+				t.Errorf("want %+v; got %+v", a.Snippets, got)
+			}
+		})
+	}
+
+	t.Run("Gist", func(t *testing.T) {
+		data := []byte(`{"description": "Scripts", "public": false, "created_at": "2024-03-01T12:00:00Z",
+			"files": {"b.sh": {"filename": "b.sh", "content": "echo b"}, "a.py": {"filename": "a.py", "content": "print('a')"}}}`)
+		got, err := readArchive(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []*archivedSnippet{
+			{Title: "Scripts (a.py)", Content: "print('a')", Created: created, Visibility: visibilityPrivate},
+			{Title: "Scripts (b.sh)", Content: "echo b", Created: created, Visibility: visibilityPrivate},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("want %+v; got %+v", want, got)
+		}
+	})
+
+	t.Run("Gists", func(t *testing.T) {
+		data := []byte(`[{"files": {"one.txt": {"content": "1"}}}, {"description": "Two", "files": {"two.txt": {"content": "2"}}}]`)
+		got, err := readArchive(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].Title != "one.txt" || got[1].Title != "Two" || got[0].Visibility != visibilityPublic {
+			t.Errorf("unexpected snippets %+v, %+v", got[0], got[1])
+		}
+	})
+
+	for name, data := range map[string]string{
+		"Newer version": `{"format": "snippetbox.snippets", "version": 99, "snippets": []}`,
+		"Unknown JSON":  `{"hello": "world"}`,
+		"Not JSON":      `hello`,
+		"Damaged zip":   "PK\x03\x04nonsense",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := readArchive([]byte(data)); err == nil {
+				t.Error("want an error; got none")
+			}
+		})
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	acme := &models.Organisation{ID: 7, Name: "Acme", Slug: "acme"}
+	archived := []*archivedSnippet{
+		{Title: " New ", Content: "a", Created: now.Add(-time.Hour), Expires: now.Add(time.Hour), Visibility: visibilityPublic},
+		{Title: "Old", Content: "b", Created: now.Add(time.Hour), Expires: now.Add(-time.Hour), Visibility: visibilityPrivate},
+		{Title: "Team", Content: "c", Visibility: visibilityOrg, Org: "acme"},
+		{Title: "Other team", Content: "d", Visibility: visibilityOrg, Org: "globex"},
+		{Title: "", Content: " ", Visibility: visibilityPublic},
+	}
+	existing := map[string]int{"Old": 3}
+	orgs := map[string]*models.Organisation{"acme": acme}
+
+	items := planImport(10, archived, existing, orgs, conflictSkip, now)
+	if len(items) != len(archived) {
+		t.Fatalf("want %d items; got %d", len(archived), len(items))
+	}
+
+	s := items[0].snippet
+	if s.UserID != 10 || s.Title != "New" || !s.Created.Equal(now.Add(-time.Hour)) || !s.Expires.Equal(now.Add(time.Hour)) || items[0].Action != importCreate {
+		t.Errorf("unexpected new snippet %+v, %+v", items[0], s)
+	}
+
+	s = items[1].snippet
+	if !s.Private || !s.Created.Equal(now) || !s.Expires.Equal(now.Add(importLifetime)) {
+		t.Errorf("unexpected old snippet %+v", s)
+	}
+	if items[1].Action != importSkip || items[1].ExistingID != 3 {
+		t.Errorf("want skipped conflict with 3; got %q, %d", items[1].Action, items[1].ExistingID)
+	}
+
+	if s = items[2].snippet; s.OrgID != acme.ID || s.Private {
+		t.Errorf("want a snippet for %d; got %+v", acme.ID, s)
+	}
+	if s = items[3].snippet; s.OrgID != 0 || !s.Private || items[3].Note == "" {
+		t.Errorf("want a private snippet with a note; got %+v, %q", s, items[3].Note)
+	}
+	if items[4].Title != "Untitled" || items[4].Action != importSkip {
+		t.Errorf("want an untitled snippet skipped; got %q, %q", items[4].Title, items[4].Action)
+	}
+
+	for conflict, action := range map[string]string{conflictKeep: importCreate, conflictReplace: importReplace} {
+		items = planImport(10, archived[1:2], existing, orgs, conflict, now)
+		if items[0].Action != action || items[0].ExistingID != 3 {
+			t.Errorf("%s: want %q of 3; got %q of %d", conflict, action, items[0].Action, items[0].ExistingID)
+		}
+	}
+}
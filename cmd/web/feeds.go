@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// summaryLength is the number of characters of a snippet's content included
+// in its feed entry.
+const summaryLength = 280
+
+// A feed describes a feed of snippets independently of its format.
+type feed struct {
+	Title    string
+	Link     string // the HTML page the feed mirrors
+	Self     string // the URL of the feed itself
+	Snippets []*models.Snippet
+}
+
+// Updated returns the time the feed last changed, which is when its most
+// recently created or edited snippet was. An empty feed was last updated at
+// the zero time.
+func (f *feed) Updated() time.Time {
+	var updated time.Time
+	for _, s := range f.Snippets {
+		if s.Updated.After(updated) {
+			updated = s.Updated
+		}
+	}
+	return updated
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Link      atomLink `xml:"link"`
+	Summary   string   `xml:"summary"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// The summarise helper shortens a snippet's content for use in a feed.
+func summarise(content string) string {
+	if utf8.RuneCountInString(content) <= summaryLength {
+		return content
+	}
+	return string([]rune(content)[:summaryLength]) + "…"
+}
+
+// The atom method encodes the feed as an Atom 1.0 document. Entry IDs are
+// the snippets' permanent URLs.
+func (f *feed) atom(base string) ([]byte, error) {
+	doc := atomFeed{
+		ID:      base + f.Link,
+		Title:   f.Title,
+		Updated: f.Updated().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "Snippetbox"},
+		Links: []atomLink{
+			{Rel: "alternate", Type: "text/html", Href: base + f.Link},
+			{Rel: "self", Type: "application/atom+xml", Href: base + f.Self},
+		},
+	}
+	for _, s := range f.Snippets {
+		link := fmt.Sprintf("%s/snippet/%d", base, s.ID)
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:        link,
+			Title:     s.Title,
+			Published: s.Created.UTC().Format(time.RFC3339),
+			Updated:   s.Updated.UTC().Format(time.RFC3339),
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: link},
+			Summary:   summarise(s.Content),
+		})
+	}
+
+	return marshalFeed(doc)
+}
+
+// The rss method encodes the feed as an RSS 2.0 document.
+func (f *feed) rss(base string) ([]byte, error) {
+	doc := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        base + f.Link,
+			Description: f.Title,
+		},
+	}
+	if updated := f.Updated(); !updated.IsZero() {
+		doc.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, s := range f.Snippets {
+		link := fmt.Sprintf("%s/snippet/%d", base, s.ID)
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       s.Title,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: true, Value: link},
+			PubDate:     s.Created.UTC().Format(time.RFC1123Z),
+			Description: summarise(s.Content),
+		})
+	}
+
+	return marshalFeed(doc)
+}
+
+func marshalFeed(doc interface{}) ([]byte, error) {
+	buf := bytes.NewBufferString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The serveFeed helper writes the feed in the format named by the file
+// extension ("atom" or "rss"). It sets the Last-Modified and ETag headers and
+// leaves it to http.ServeContent to answer conditional requests with a 304
+// Not Modified response.
+func (app *application) serveFeed(w http.ResponseWriter, r *http.Request, f *feed, format string) {
+	base := absoluteURL(r, "")
+
+	var body []byte
+	var err error
+	switch format {
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		body, err = f.atom(base)
+	case "rss":
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		body, err = f.rss(base)
+	default:
+		app.notFound(w)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", strconv.Quote(hex.EncodeToString(sum[:16])))
+	http.ServeContent(w, r, "", f.Updated(), bytes.NewReader(body))
+}
+
+func (app *application) latestFeed(w http.ResponseWriter, r *http.Request) {
+	// Feeds are fetched without a session, so they only ever list the
+	// snippets an anonymous visitor can see.
+	s, err := app.snippets.Latest(models.Viewer{})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.serveFeed(w, r, &feed{
+		Title:    "Latest snippets on Snippetbox",
+		Link:     "/",
+		Self:     r.URL.Path,
+		Snippets: s,
+	}, r.URL.Query().Get(":format"))
+}
+
+func (app *application) userFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, err := app.snippets.ByUser(user.ID, 0, pageSize, models.Viewer{})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.serveFeed(w, r, &feed{
+		Title:    fmt.Sprintf("Snippets by %s on Snippetbox", user.Name),
+		Link:     fmt.Sprintf("/user/%d/snippets", user.ID),
+		Self:     r.URL.Path,
+		Snippets: s,
+	}, r.URL.Query().Get(":format"))
+}
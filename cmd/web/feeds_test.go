@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+func TestServeFeed(t *testing.T) {
+	app := &application{}
+	f := &feed{
+		Title: "Latest snippets",
+		Link:  "/",
+		Self:  "/feed.atom",
+		Snippets: []*models.Snippet{
+			{ID: 2, Title: "Newer", Content: strings.Repeat("x", 300), Created: time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC), Updated: time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC)},
+			{ID: 1, Title: "Older", Content: "An old silent pond", Created: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), Updated: time.Date(2023, 3, 2, 12, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	for _, format := range []string{"atom", "rss"} {
+		t.Run(format, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/feed."+format, nil)
+			app.serveFeed(rr, r, f, format)
+
+			rs := rr.Result()
+			if rs.StatusCode != http.StatusOK {
+				t.Fatalf("want %d; got %d", http.StatusOK, rs.StatusCode)
+			}
+			if lm := rs.Header.Get("Last-Modified"); lm != "Thu, 02 Mar 2023 12:00:00 GMT" {
+				t.Errorf("want Last-Modified of the latest edit; got %q", lm)
+			}
+
+			// The document must be well-formed and link to the snippets.
+			body := rr.Body.String()
+			if err := xml.Unmarshal([]byte(body), new(struct{})); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(body, "https://example.com/snippet/2") {
+				t.Errorf("want body to link to snippet 2")
+			}
+			if strings.Contains(body, strings.Repeat("x", 281)) {
+				t.Errorf("want content to be summarised")
+			}
+
+			// Repeating the request with the ETag should be answered with a
+			// 304 Not Modified.
+			rr = httptest.NewRecorder()
+			r = httptest.NewRequest("GET", "/feed."+format, nil)
+			r.Header.Set("If-None-Match", rs.Header.Get("ETag"))
+			app.serveFeed(rr, r, f, format)
+			if rr.Code != http.StatusNotModified {
+				t.Errorf("want %d for matching ETag; got %d", http.StatusNotModified, rr.Code)
+			}
+
+			// And so should one with an If-Modified-Since date.
+			rr = httptest.NewRecorder()
+			r = httptest.NewRequest("GET", "/feed."+format, nil)
+			r.Header.Set("If-Modified-Since", "Fri, 03 Mar 2023 00:00:00 GMT")
+			app.serveFeed(rr, r, f, format)
+			if rr.Code != http.StatusNotModified {
+				t.Errorf("want %d for If-Modified-Since; got %d", http.StatusNotModified, rr.Code)
+			}
+		})
+	}
+}
@@ -3,23 +3,50 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
 	"github.com/ardianeffendi/snippetbox/pkg/models"
+	"github.com/justinas/nosurf"
 )
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	s, err := app.snippets.Latest()
+	// The listing is ordered by creation date unless the most starred
+	// snippets of the past week have been asked for.
+	sort := r.URL.Query().Get("sort")
+
+	td := &templateData{Sort: sort}
+	var err error
+	if sort == "stars" {
+		td.Snippets, err = app.snippets.MostStarred(app.viewer(r))
+	} else {
+		td.Sort = "latest"
+		page, ok := pageNumber(r)
+		if !ok {
+			app.notFound(w)
+			return
+		}
+
+		// Fetch one snippet more than fits on the page to find out whether
+		// there's a next page.
+		td.Snippets, err = app.snippets.List((page-1)*pageSize, pageSize+1, app.viewer(r))
+		if len(td.Snippets) > pageSize {
+			td.Snippets = td.Snippets[:pageSize]
+			td.NextPage = page + 1
+		}
+		td.PrevPage = page - 1
+	}
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
 	// Use the render() function helper
-	app.render(w, r, "home.page.tmpl", &templateData{
-		Snippets: s,
-	})
+	app.render(w, r, "home.page.tmpl", td)
 }
 
 func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
@@ -30,29 +57,136 @@ func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Use the SnippetModel object's Get method to retrieve the data for a
-	// specific record based on its ID. If no matching record is found,
-	// return a 404 Not Found response.
-	s, err := app.snippets.Get(id)
+	// specific record based on its ID. If no matching record is found, or
+	// the user can't see it, return a 404 Not Found response. Snippets taken
+	// down for legal reasons are 451 Unavailable For Legal Reasons instead.
+	// The moderation queue links here, so moderators can see any snippet.
+	s, err := app.snippets.Get(id, app.moderatorViewer(r))
 	if err == models.ErrNoRecord {
 		app.notFound(w)
 		return
+	} else if err == models.ErrTakenDown {
+		app.clientError(w, http.StatusUnavailableForLegalReasons)
+		return
 	} else if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
+	// Count the view. This only updates an in-memory buffer, so it doesn't
+	// slow the response down.
+	app.views.Record(s.ID, r)
+
+	// Prefill the comment form's line number when a range of lines has been
+	// linked to, so that commenting on it is a single step.
+	form := forms.New(url.Values{})
+	if lr, ok := parseLineRange(r.URL.Query().Get("lines")); ok {
+		form.Set("line", strconv.Itoa(lr.Start))
+	}
+
+	app.renderSnippet(w, r, s, form)
+}
+
+// The renderSnippet helper gathers everything shown alongside a snippet and
+// renders the show page. The form is the comment form, which is redisplayed
+// with its errors when a comment fails validation. Lines given in the "lines"
+// query string parameter (like "?lines=10-20") are highlighted.
+func (app *application) renderSnippet(w http.ResponseWriter, r *http.Request, s *models.Snippet, form *forms.Form) {
+	user := app.authenticatedUser(r)
+
+	// Let a logged in user see whether they've already starred the snippet.
+	var starred bool
+	if user != nil {
+		var err error
+		starred, err = app.stars.Starred(user.ID, s.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	comments, err := app.comments.ForSnippet(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Comments on specific lines are shown inline with the content, and the
+	// rest below it.
+	threaded := threadComments(s, comments, user)
+	general := []*threadedComment{}
+	for _, c := range threaded {
+		c.CSRFToken = nosurf.Token(r)
+		if c.Line == 0 {
+			general = append(general, c)
+		}
+	}
+	highlight, _ := parseLineRange(r.URL.Query().Get("lines"))
+
+	var org *models.Organisation
+	if s.OrgID != 0 {
+		org, err = app.orgs.Get(s.OrgID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	// The author gets the share dialog, listing who they've shared it with
+	// and, unless it's public, its share links.
+	var shares []*models.Share
+	var links []*shareLink
+	if user != nil && s.UserID == user.ID {
+		shares, err = app.shares.ForSnippet(s.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if s.Private || s.OrgID != 0 {
+			links, err = app.shareLinks(r, s)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+		}
+	}
+
 	app.render(w, r, "show.page.tmpl", &templateData{
-		Snippet: s,
+		AccessLevels:  models.AccessLevels,
+		Comments:      general,
+		Form:          form,
+		Lines:         snippetLines(s.Content, highlight, threaded),
+		Organisation:  org,
+		ReportReasons: models.ReportReasons,
+		ShareLinks:    links,
+		Shares:        shares,
+		Snippet:       s,
+		Starred:       starred,
 	})
 }
 
-func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
+// The renderCreateSnippet helper shows the create snippet form, with the
+// organisations the user can post the snippet to as well as the choice
+// between public and private.
+func (app *application) renderCreateSnippet(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	orgs, err := app.orgs.ForUser(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	app.render(w, r, "create.page.tmpl", &templateData{
-		// Pass a new empty forms.Form object to the template.
-		Form: forms.New(nil),
+		Form:          form,
+		Organisations: orgs,
 	})
 }
 
+func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
+	// Pass a new forms.Form object to the template, with the organisation
+	// chosen if the user came from its page.
+	app.renderCreateSnippet(w, r, forms.New(url.Values{"visibility": {r.URL.Query().Get("org")}}))
+}
+
 func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	// Call r.ParseForm() to add any data in POST request bodies
 	// to the r.PostForm map. This also works in the same way for PUT and PATCH
@@ -71,20 +205,37 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	form.MaxLength("title", 100)
 	form.PermittedValues("expires", "365", "7", "1")
 
+	// A snippet is public, private, or visible to the members of an
+	// organisation, given by its ID. Snippets can only be posted to
+	// organisations the user belongs to.
+	user := app.authenticatedUser(r)
+	orgID := 0
+	private := false
+	switch v := form.Get("visibility"); v {
+	case "":
+	case "private":
+		private = true
+	default:
+		orgID, err = strconv.Atoi(v)
+		if err != nil || !app.isMember(orgID, user.ID) {
+			form.Errors.Add("visibility", "This field is invalid")
+		}
+	}
+
 	// If the form isn't valid, redisplay the template passing in the
 	// form.Form object as the data.
 	if !form.Valid() {
-		app.render(w, r, "create.page.tmpl", &templateData{
-			Form: form,
-		})
+		app.renderCreateSnippet(w, r, form)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Get("title"), form.Get("content"), form.Get("expires"))
+	id, err := app.snippets.Insert(user.ID, orgID, private, form.Get("title"), form.Get("content"), form.Get("expires"))
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditSnippetCreate, SnippetID: id})
+	app.snippetEventByID(models.EventSnippetCreated, id)
 
 	// Use the Put() method to add a string value ("Your snippet was saved
 	// successfully!") and the corresponding key ("flash") to the session
@@ -97,6 +248,378 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
 }
 
+// The editableSnippet helper returns the snippet with the ID from the URL,
+// if the current user can change it: they wrote it, or it has been shared
+// with them for editing.
+func (app *application) editableSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+
+	s, err := app.snippets.Get(id, app.viewer(r))
+	if err == models.ErrNoRecord || err == models.ErrTakenDown {
+		app.notFound(w)
+		return nil, false
+	} else if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	if !s.Editable {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return s, true
+}
+
+func (app *application) editSnippetForm(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.editableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	app.render(w, r, "edit.page.tmpl", &templateData{
+		Form:    forms.New(url.Values{"title": {s.Title}, "content": {s.Content}}),
+		Snippet: s,
+	})
+}
+
+func (app *application) editSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.editableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("title", "content")
+	form.MaxLength("title", 100)
+	if !form.Valid() {
+		app.render(w, r, "edit.page.tmpl", &templateData{Form: form, Snippet: s})
+		return
+	}
+
+	err = app.snippets.Update(s.ID, form.Get("title"), form.Get("content"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditSnippetEdit, SnippetID: s.ID})
+	app.snippetEventByID(models.EventSnippetUpdated, s.ID)
+
+	app.session.Put(r, "flash", "Your changes have been saved.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", s.ID), http.StatusSeeOther)
+}
+
+func (app *application) forkSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	// Copy the snippet into a new one owned by the current user. If the
+	// original has expired (or never existed, or the user can't see it)
+	// there's nothing to fork.
+	newID, err := app.snippets.Fork(id, app.viewer(r))
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditSnippetCreate, SnippetID: newID, Details: fmt.Sprintf("fork of #%d", id)})
+	app.snippetEventByID(models.EventSnippetCreated, newID)
+
+	app.session.Put(r, "flash", "Snippet successfully forked!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", newID), http.StatusSeeOther)
+}
+
+func (app *application) showForks(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	s, err := app.snippets.Get(id, app.viewer(r))
+	if err == models.ErrNoRecord || err == models.ErrTakenDown {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	forks, err := app.snippets.Forks(id, app.viewer(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "forks.page.tmpl", &templateData{
+		Snippet:  s,
+		Snippets: forks,
+	})
+}
+
+func (app *application) starSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The form carries the desired state rather than asking for a toggle,
+	// so a repeated submission (say, a double click) has no further effect.
+	starred, err := strconv.ParseBool(r.PostForm.Get("star"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Make sure the snippet exists and hasn't expired before starring it.
+	_, err = app.snippets.Get(id, app.viewer(r))
+	if err == models.ErrNoRecord || err == models.ErrTakenDown {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.stars.Set(app.authenticatedUser(r).ID, id, starred)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
+}
+
+func (app *application) userSnippets(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	page, ok := pageNumber(r)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, err := app.snippets.ByUser(user.ID, (page-1)*pageSize, pageSize+1, app.viewer(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td := &templateData{
+		Snippets: s,
+		PrevPage: page - 1,
+		User:     user,
+	}
+	if len(s) > pageSize {
+		td.Snippets = s[:pageSize]
+		td.NextPage = page + 1
+	}
+
+	app.render(w, r, "user.page.tmpl", td)
+}
+
+func (app *application) userStars(w http.ResponseWriter, r *http.Request) {
+	s, err := app.stars.ForUser(app.viewer(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "stars.page.tmpl", &templateData{
+		Snippets: s,
+	})
+}
+
+func (app *application) createComment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	s, err := app.snippets.Get(id, app.viewer(r))
+	if err == models.ErrNoRecord || err == models.ErrTakenDown {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("content")
+	form.MaxLength("content", 2000)
+
+	// A comment can be attached to a line of the snippet.
+	line := 0
+	if l := form.Get("line"); l != "" {
+		line, err = strconv.Atoi(l)
+		if err != nil || line < 1 || line > strings.Count(s.Content, "\n")+1 {
+			form.Errors.Add("line", "This line does not exist")
+		}
+	}
+
+	// A reply must answer a comment on the same snippet, and is attached to
+	// the same line.
+	parentID := 0
+	if p := form.Get("parent_id"); p != "" {
+		parentID, err = strconv.Atoi(p)
+		if err != nil || parentID < 1 {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+		parent, err := app.comments.Get(parentID)
+		if err == models.ErrNoRecord || (err == nil && parent.SnippetID != s.ID) {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		line = parent.Line
+	}
+
+	if !form.Valid() {
+		app.renderSnippet(w, r, s, form)
+		return
+	}
+
+	commentID, err := app.comments.Insert(s.ID, app.authenticatedUser(r).ID, parentID, line, form.Get("content"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d#comment-%d", s.ID, commentID), http.StatusSeeOther)
+}
+
+func (app *application) deleteComment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	c, err := app.comments.Get(id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, err := app.snippets.Get(c.SnippetID, app.viewer(r))
+	if err == models.ErrNoRecord || err == models.ErrTakenDown {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Only the comment's author and the snippet's owner may delete it.
+	user := app.authenticatedUser(r)
+	if user.ID != c.UserID && (s.UserID == 0 || user.ID != s.UserID) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.comments.Delete(c.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Comment deleted.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", s.ID), http.StatusSeeOther)
+}
+
+func (app *application) snippetStats(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	s, err := app.snippets.Get(id, app.viewer(r))
+	if err == models.ErrNoRecord || err == models.ErrTakenDown {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Only the owner of a snippet gets to see who's reading it.
+	if s.UserID == 0 || s.UserID != app.authenticatedUser(r).ID {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	views, visitors, err := app.viewStats.Totals(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -statsDays+1)
+	daily, err := app.viewStats.Daily(s.ID, since)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "stats.page.tmpl", &templateData{
+		Snippet: s,
+		Stats: &snippetStats{
+			Views:    views,
+			Visitors: visitors,
+			Days:     chartDays(since, statsDays, daily),
+		},
+	})
+}
+
 func (app *application) signupUserForm(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, "signup.page.tmpl", &templateData{
 		Form: forms.New(nil),
@@ -124,18 +647,27 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 
 	// Try to create a new user record in the database. If the email already exists,
 	// add an error message to the form and re-display it.
-	err = app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
+	id, err := app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
 	if err == models.ErrDuplicateEmail {
 		form.Errors.Add("email", "Address is already in use")
 		app.render(w, r, "signup.page.tmpl", &templateData{Form: form})
 		return
 	} else if err != nil {
 		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditSignup, ActorID: id, UserID: id, Email: form.Get("email")})
+
+	// Email the new user a link to verify their address.
+	err = app.sendVerification(r, &models.User{ID: id, Name: form.Get("name"), Email: form.Get("email")})
+	if err != nil {
+		app.serverError(w, err)
+		return
 	}
 
 	// Otherwise add a confirmation flash message to the session confirming that
 	// their signup worked and asking them to log in.
-	app.session.Put(r, "flash", "Your signup was successful. Please log in.")
+	app.session.Put(r, "flash", "Your signup was successful. We've emailed you a link to verify your address. Please log in.")
 
 	// Redirect the user to the login page.
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -151,13 +683,37 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
+		return
 	}
 
 	// Check whether the credentials are valid. If they're not, add a generic error
 	// message to the form failures map and re-display the login page.
 	form := forms.New(r.PostForm)
-	id, err := app.users.Authenticate(form.Get("email"), form.Get("password"))
+	email := form.Get("email")
+
+	// Count the attempt as a failure before checking the password, so that
+	// logins made at the same time can't all get in under the limit, and
+	// refuse it without checking if there have been too many failures for
+	// the account or from the IP address recently.
+	wait, lockedOut, err := app.loginThrottle.Reserve(r, email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if wait > 0 {
+		app.audit(r, &models.AuditEvent{Action: models.AuditLoginFailed, Email: email, Details: "locked out"})
+		form.Errors.Add("generic", "Too many failed login attempts. Please try again later.")
+		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	id, err := app.users.Authenticate(email, form.Get("password"))
 	if err == models.ErrInvalidCredentials {
+		app.audit(r, &models.AuditEvent{Action: models.AuditLoginFailed, Email: email, Details: "password"})
+		if lockedOut {
+			app.notifyLockout(r, email)
+		}
+
 		form.Errors.Add("generic", "Email or password is incorrect")
 		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
 		return
@@ -166,16 +722,281 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = app.loginThrottle.Succeed(r, email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Users with two-factor authentication turned on aren't logged in yet:
+	// they still have to enter a code.
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.TwoFactor {
+		app.startTwoFactorLogin(w, r, id, form.Get("remember") != "")
+		return
+	}
+
 	// Add the ID of the current user to the session.
-	app.session.Put(r, "userID", id)
+	err = app.logIn(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditLogin, ActorID: id, UserID: id, Details: "password"})
+
+	// Keep them logged in on this device if they asked to be remembered.
+	if form.Get("remember") != "" {
+		err = app.rememberUser(w, r, id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
 
 	// Redirect the user to the create snippet page.
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
+func (app *application) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "forgot.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+	if !form.Valid() {
+		app.render(w, r, "forgot.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// Limit how often links can be asked for, both for each address and
+	// from each client, so that the form can't be used to flood inboxes.
+	// It's the same whether or not there's an account for the address. The
+	// client's limit is checked first, so no client can make the limiter
+	// keep track of more than a few addresses.
+	if !app.resetIPLimiter.Allow(remoteIP(r)) || !app.resetLimiter.Allow(strings.ToLower(form.Get("email"))) {
+		form.Errors.Add("email", "We've had several requests for this recently. Please wait a while before asking again.")
+		app.render(w, r, "forgot.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// Only send an email if there's an account for the address, but respond
+	// in the same way either way so that the form can't be used to find out
+	// who has an account.
+	user, err := app.users.GetByEmail(form.Get("email"))
+	if err != nil && err != models.ErrNoRecord {
+		app.serverError(w, err)
+		return
+	}
+	if user != nil {
+		// Only the newest link should work.
+		err = app.tokens.DeleteAllForUser(models.ScopePasswordReset, user.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		token, err := app.tokens.New(user.ID, time.Hour, models.ScopePasswordReset)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.sendMail(&mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Snippetbox password",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone (hopefully you) asked to reset your Snippetbox password. "+
+				"To choose a new one, visit:\n\n%s\n\nThe link can be used once and expires in an hour. "+
+				"If you didn't ask for this, you can ignore this email.\n",
+				user.Name, absoluteURL(r, "/user/password/reset?token="+url.QueryEscape(token))),
+		})
+	}
+
+	app.session.Put(r, "flash", "If there's an account for that address, we've emailed it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(url.Values{})
+	form.Set("token", r.URL.Query().Get("token"))
+
+	app.render(w, r, "reset.page.tmpl", &templateData{
+		Form: form,
+	})
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("token", "password", "confirm")
+	form.MinLength("password", 10)
+	form.MatchesField("confirm", "password")
+	if !form.Valid() {
+		app.render(w, r, "reset.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// Using up the token is the last check, so that a typo in the new
+	// password doesn't waste the emailed link.
+	userID, err := app.tokens.Consume(form.Get("token"), models.ScopePasswordReset)
+	if err == models.ErrInvalidToken {
+		form.Errors.Add("generic", "This reset link is invalid or has expired. Please ask for a new one.")
+		app.render(w, r, "reset.page.tmpl", &templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.UpdatePassword(userID, form.Get("password"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditPasswordReset, ActorID: userID, UserID: userID})
+
+	// Any other reset links that were sent are no longer needed.
+	err = app.tokens.DeleteAllForUser(models.ScopePasswordReset, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Whoever knew the old password may be logged in, so end all of the
+	// user's sessions.
+	err = app.sessions.DeleteAllForUser(userID, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.remember.DeleteAllForUser(userID, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your password has been changed. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) verifyUserForm(w http.ResponseWriter, r *http.Request) {
+	// Following the emailed link only shows a confirmation button. Using up
+	// the token on a GET request would let link scanners in mail clients
+	// verify addresses by accident.
+	form := forms.New(url.Values{})
+	form.Set("token", r.URL.Query().Get("token"))
+
+	app.render(w, r, "verify.page.tmpl", &templateData{
+		Form: form,
+	})
+}
+
+func (app *application) verifyUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	userID, err := app.tokens.Consume(form.Get("token"), models.ScopeVerification)
+	if err == models.ErrInvalidToken {
+		form.Errors.Add("generic", "This verification link is invalid or has expired.")
+		app.render(w, r, "verify.page.tmpl", &templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.SetVerified(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.tokens.DeleteAllForUser(models.ScopeVerification, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Thanks, your email address has been verified.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) verificationStatus(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "verification.page.tmpl", nil)
+}
+
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user.Verified {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// Limit how often each user can have the email resent, so that the
+	// endpoint can't be used to flood someone's inbox.
+	if !app.resendLimiter.Allow(strconv.Itoa(user.ID)) {
+		app.session.Put(r, "flash", "We've sent you several emails recently. Please wait a while before asking for another.")
+		http.Redirect(w, r, "/user/verification", http.StatusSeeOther)
+		return
+	}
+
+	// Only the newest link should work.
+	err := app.tokens.DeleteAllForUser(models.ScopeVerification, user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.sendVerification(r, user)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "We've sent you a new verification email.")
+	http.Redirect(w, r, "/user/verification", http.StatusSeeOther)
+}
+
 func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
-	// Remove the userID from the session data so that the user is 'logged out'
-	app.session.Remove(r, "userID")
+	user := app.authenticatedUser(r)
+
+	// End the server-side session and remove its ID from the session data
+	// so that the user is 'logged out'
+	err := app.sessions.Delete(user.ID, app.currentSession(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.remember.DeleteForSession(user.ID, app.currentSession(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Remove(r, "sessionID")
+	clearRememberCookie(w)
+	app.audit(r, &models.AuditEvent{Action: models.AuditLogout, ActorID: user.ID, UserID: user.ID})
 
 	// add a flash message to the session to confirm to the user that they've
 	// been logged out
@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
 	"github.com/ardianeffendi/snippetbox/pkg/models"
 	"github.com/justinas/nosurf"
 )
@@ -34,6 +38,56 @@ func (app *application) notFound(w http.ResponseWriter) {
 	app.clientError(w, http.StatusNotFound)
 }
 
+// The absoluteURL helper turns a path into a full URL on the host the
+// request was made to, for use in emails and feeds. The application is only
+// ever served over HTTPS.
+func absoluteURL(r *http.Request, path string) string {
+	return "https://" + r.Host + path
+}
+
+// The background helper runs fn in a new goroutine, logging (rather than
+// crashing on) any panic, since panics in other goroutines aren't caught by
+// the recoverPanic middleware. It's used for slow work like sending email
+// which shouldn't hold up the response.
+func (app *application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Output(2, fmt.Sprintf("%s\n%s", err, debug.Stack()))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// The sendMail helper sends an email in the background, logging any error.
+func (app *application) sendMail(msg *mailer.Message) {
+	app.background(func() {
+		if err := app.mailer.Send(msg); err != nil {
+			app.errorLog.Printf("sending %q to %s: %s", msg.Subject, msg.To, err)
+		}
+	})
+}
+
+// The sendVerification helper creates a verification token for the user
+// and emails them the link to verify their address with.
+func (app *application) sendVerification(r *http.Request, user *models.User) error {
+	token, err := app.tokens.New(user.ID, 72*time.Hour, models.ScopeVerification)
+	if err != nil {
+		return err
+	}
+
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\nThanks for signing up to Snippetbox. To verify your email address, visit:\n\n%s\n\n"+
+			"The link expires in three days.\n",
+			user.Name, absoluteURL(r, "/user/verify?token="+url.QueryEscape(token))),
+	})
+	return nil
+}
+
 // Create an addDefaultData helper. This takes a pointer to a templateData
 // struct and then returns the pointer.
 func (app *application) addDefaultData(td *templateData, r *http.Request) *templateData {
@@ -45,9 +99,37 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 	td.AuthenticatedUser = app.authenticatedUser(r)
 	td.CurrentYear = time.Now().Year()
 	td.Flash = app.session.PopString(r, "flash")
+	td.SSO = app.oidc != nil
 	return td
 }
 
+// The logIn helper logs the user in, by starting a new server-side session
+// for them.
+func (app *application) logIn(r *http.Request, id int) error {
+	return app.startSession(r, id)
+}
+
+// The viewer helper returns who snippets are being read for in this
+// request, which the snippet models use to decide which ones can be seen.
+func (app *application) viewer(r *http.Request) models.Viewer {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		return models.Viewer{}
+	}
+	return models.Viewer{UserID: user.ID}
+}
+
+// The moderatorViewer helper is viewer for the pages moderators deal with
+// reports on, where they can see every snippet. Everywhere else, listings
+// included, they see the same snippets as anyone else.
+func (app *application) moderatorViewer(r *http.Request) models.Viewer {
+	v := app.viewer(r)
+	if user := app.authenticatedUser(r); user != nil && user.HasRole(models.RoleModerator) {
+		v.All = true
+	}
+	return v
+}
+
 // The authenticatedUser returns the User's struct of the current user from
 // context and it returns nil if user is no authenticated and valid user.
 func (app *application) authenticatedUser(r *http.Request) *models.User {
@@ -81,3 +163,183 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, name stri
 	// Write the content to http.ResponseWriter as it is safe to do so
 	buf.WriteTo(w)
 }
+
+// A threadedComment is a comment prepared for display: Depth is how many
+// replies deep it is, and CanReply and CanDelete report what the current
+// user may do with it. CSRFToken is carried along for the comment's forms,
+// since the "comment" template is rendered with the comment as its data.
+type threadedComment struct {
+	*models.Comment
+	Depth     int
+	CanReply  bool
+	CanDelete bool
+	CSRFToken string
+}
+
+// The threadComments helper orders a snippet's comments so that each reply
+// directly follows the comment it answers, and works out which of them the
+// given user (which may be nil) is allowed to delete. The comment's author
+// and the snippet's owner can both delete it.
+func threadComments(s *models.Snippet, comments []*models.Comment, user *models.User) []*threadedComment {
+	replies := map[int][]*models.Comment{}
+	for _, c := range comments {
+		replies[c.ParentID] = append(replies[c.ParentID], c)
+	}
+
+	threaded := []*threadedComment{}
+	var walk func(parentID, depth int)
+	walk = func(parentID, depth int) {
+		for _, c := range replies[parentID] {
+			tc := &threadedComment{Comment: c, Depth: depth}
+			if user != nil {
+				tc.CanReply = true
+				tc.CanDelete = user.ID == c.UserID || (s.UserID != 0 && user.ID == s.UserID)
+			}
+			threaded = append(threaded, tc)
+			walk(c.ID, depth+1)
+		}
+	}
+	walk(0, 0)
+
+	return threaded
+}
+
+// pageSize is the number of snippets shown on each page of a listing.
+const pageSize = 20
+
+// The pageNumber helper reads the 1-based page number of a listing from the
+// "page" query string parameter, which defaults to the first page.
+func pageNumber(r *http.Request) (int, bool) {
+	p := r.URL.Query().Get("page")
+	if p == "" {
+		return 1, true
+	}
+
+	page, err := strconv.Atoi(p)
+	if err != nil || page < 1 {
+		return 0, false
+	}
+	return page, true
+}
+
+// A lineRange is an inclusive range of 1-based line numbers. The zero value
+// is an empty range.
+type lineRange struct {
+	Start, End int
+}
+
+// Contains reports whether line n is inside the range.
+func (lr lineRange) Contains(n int) bool {
+	return lr.Start > 0 && n >= lr.Start && n <= lr.End
+}
+
+// The parseLineRange helper parses the line numbers used in snippet
+// permalinks, which are written as "10" or "10-20", optionally with the "L"
+// prefix used by the anchors on the page (like "L10-L20"). A backwards range
+// is turned around.
+func parseLineRange(s string) (lineRange, bool) {
+	start, end, found := strings.Cut(s, "-")
+	if !found {
+		end = start
+	}
+
+	a, err := strconv.Atoi(strings.TrimPrefix(start, "L"))
+	if err != nil || a < 1 {
+		return lineRange{}, false
+	}
+	b, err := strconv.Atoi(strings.TrimPrefix(end, "L"))
+	if err != nil || b < 1 {
+		return lineRange{}, false
+	}
+	if b < a {
+		a, b = b, a
+	}
+
+	return lineRange{Start: a, End: b}, true
+}
+
+// A snippetLine is a single numbered line of a snippet's content together
+// with the comment threads attached to it.
+type snippetLine struct {
+	Number      int
+	Text        string
+	Highlighted bool
+	Comments    []*threadedComment
+}
+
+// The snippetLines helper splits a snippet's content into numbered lines,
+// marks the lines in the highlighted range and hangs the line comments off
+// the lines they refer to. Comments on lines which no longer exist are
+// attached to the last line.
+func snippetLines(content string, highlight lineRange, comments []*threadedComment) []*snippetLine {
+	texts := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+
+	lines := make([]*snippetLine, len(texts))
+	for i, text := range texts {
+		lines[i] = &snippetLine{
+			Number:      i + 1,
+			Text:        text,
+			Highlighted: highlight.Contains(i + 1),
+		}
+	}
+
+	// The comments are already in thread order, and replies share the line
+	// of the comment they answer, so appending keeps each thread together.
+	for _, c := range comments {
+		if c.Line == 0 {
+			continue
+		}
+		n := c.Line
+		if n > len(lines) {
+			n = len(lines)
+		}
+		lines[n-1].Comments = append(lines[n-1].Comments, c)
+	}
+
+	return lines
+}
+
+// statsDays is the number of days shown on a snippet's stats chart.
+const statsDays = 30
+
+// snippetStats holds the view statistics shown on a snippet's stats page.
+type snippetStats struct {
+	Views    int
+	Visitors int
+	Days     []*chartDay
+}
+
+// A chartDay is one bar of the daily views chart. Percent is the height of
+// the bar relative to the busiest day.
+type chartDay struct {
+	models.DailyViews
+	Percent int
+}
+
+// The chartDays helper lays the daily view counts out over a run of
+// consecutive days starting at since, filling in the days without any views
+// with zeroes.
+func chartDays(since time.Time, n int, daily []*models.DailyViews) []*chartDay {
+	byDay := map[string]*models.DailyViews{}
+	max := 0
+	for _, d := range daily {
+		byDay[d.Day.UTC().Format("2006-01-02")] = d
+		if d.Views > max {
+			max = d.Views
+		}
+	}
+
+	days := make([]*chartDay, n)
+	for i := range days {
+		day := since.UTC().AddDate(0, 0, i)
+		cd := &chartDay{DailyViews: models.DailyViews{Day: day}}
+		if d, ok := byDay[day.Format("2006-01-02")]; ok {
+			cd.Views = d.Views
+			cd.Visitors = d.Visitors
+			cd.Percent = d.Views * 100 / max
+		}
+		days[i] = cd
+	}
+
+	return days
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+func TestThreadComments(t *testing.T) {
+	s := &models.Snippet{ID: 1, UserID: 10}
+	comments := []*models.Comment{
+		{ID: 1, UserID: 20},
+		{ID: 2, UserID: 30},
+		{ID: 3, UserID: 30, ParentID: 1},
+		{ID: 4, UserID: 20, ParentID: 3},
+	}
+
+	tests := []struct {
+		name      string
+		user      *models.User
+		wantOrder []int
+		wantDepth []int
+		canDelete []bool
+	}{
+		{
+			name:      "Anonymous",
+			user:      nil,
+			wantOrder: []int{1, 3, 4, 2},
+			wantDepth: []int{0, 1, 2, 0},
+			canDelete: []bool{false, false, false, false},
+		},
+		{
+			name:      "Author",
+			user:      &models.User{ID: 30},
+			wantOrder: []int{1, 3, 4, 2},
+			wantDepth: []int{0, 1, 2, 0},
+			canDelete: []bool{false, true, false, true},
+		},
+		{
+			name:      "Snippet owner",
+			user:      &models.User{ID: 10},
+			wantOrder: []int{1, 3, 4, 2},
+			wantDepth: []int{0, 1, 2, 0},
+			canDelete: []bool{true, true, true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threaded := threadComments(s, comments, tt.user)
+			if len(threaded) != len(tt.wantOrder) {
+				t.Fatalf("want %d comments; got %d", len(tt.wantOrder), len(threaded))
+			}
+
+			for i, tc := range threaded {
+				if tc.ID != tt.wantOrder[i] {
+					t.Errorf("position %d: want comment %d; got %d", i, tt.wantOrder[i], tc.ID)
+				}
+				if tc.Depth != tt.wantDepth[i] {
+					t.Errorf("comment %d: want depth %d; got %d", tc.ID, tt.wantDepth[i], tc.Depth)
+				}
+				if tc.CanDelete != tt.canDelete[i] {
+					t.Errorf("comment %d: want CanDelete %t; got %t", tc.ID, tt.canDelete[i], tc.CanDelete)
+				}
+			}
+		})
+	}
+}
+
+func TestParseLineRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      string
+		want   lineRange
+		wantOK bool
+	}{
+		{name: "Single", s: "10", want: lineRange{10, 10}, wantOK: true},
+		{name: "Range", s: "10-20", want: lineRange{10, 20}, wantOK: true},
+		{name: "Anchor", s: "L10-L20", want: lineRange{10, 20}, wantOK: true},
+		{name: "Backwards", s: "20-10", want: lineRange{10, 20}, wantOK: true},
+		{name: "Empty", s: "", wantOK: false},
+		{name: "Zero", s: "0-3", wantOK: false},
+		{name: "Garbage", s: "L1-x", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLineRange(tt.s)
+			if ok != tt.wantOK {
+				t.Fatalf("want ok %t; got %t", tt.wantOK, ok)
+			}
+			if got != tt.want {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSnippetLines(t *testing.T) {
+	comments := []*threadedComment{
+		{Comment: &models.Comment{ID: 1}},
+		{Comment: &models.Comment{ID: 2, Line: 2}},
+		{Comment: &models.Comment{ID: 3, Line: 9}},
+	}
+
+	lines := snippetLines("one\r\ntwo\nthree", lineRange{2, 3}, comments)
+	if len(lines) != 3 {
+		t.Fatalf("want 3 lines; got %d", len(lines))
+	}
+
+	for i, want := range []string{"one", "two", "three"} {
+		if lines[i].Number != i+1 || lines[i].Text != want {
+			t.Errorf("want line %d %q; got %d %q", i+1, want, lines[i].Number, lines[i].Text)
+		}
+	}
+	if lines[0].Highlighted || !lines[1].Highlighted || !lines[2].Highlighted {
+		t.Errorf("want lines 2-3 highlighted")
+	}
+	if len(lines[0].Comments) != 0 || len(lines[1].Comments) != 1 || len(lines[2].Comments) != 1 {
+		t.Errorf("want line comments on lines 2 and 3")
+	}
+}
+
+func TestDescribeUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:109.0) Gecko/20100101 Firefox/110.0", "Firefox on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.3 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36", "Chrome on Linux"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36 Edg/110.0.1587.57", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 16_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/110.0.5481.114 Mobile/15E148 Safari/604.1", "Chrome on iOS"},
+		{"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/7.88.1", "curl"},
+		{"", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		if got := describeUserAgent(tt.ua); got != tt.want {
+			t.Errorf("%q: want %q; got %q", tt.ua, tt.want, got)
+		}
+	}
+}
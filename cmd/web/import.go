@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// Limits on what can be imported at once.
+const (
+	maxImportSize     = 10 << 20
+	maxImportSnippets = 1000
+)
+
+// importLifetime is how long imported snippets last when the archive
+// doesn't say, or says they've already expired. It's the longest choice
+// offered when creating a snippet.
+const importLifetime = 365 * 24 * time.Hour
+
+// What importing a snippet does.
+const (
+	importCreate  = "create"
+	importReplace = "replace"
+	importSkip    = "skip"
+)
+
+// Ways of dealing with an imported snippet which has the same title as
+// one the user already has.
+const (
+	conflictSkip    = "skip"
+	conflictKeep    = "keep"
+	conflictReplace = "replace"
+)
+
+// importItem is what importing one snippet from an archive does, or would
+// do in a dry run. ExistingID is the user's snippet it conflicts with, if
+// any, and ID the snippet it was imported as.
+type importItem struct {
+	Title      string
+	Visibility string
+	Action     string
+	ExistingID int
+	ID         int
+	Note       string
+	snippet    *models.Snippet
+}
+
+// importResult is shown after an import or a dry run of one.
+type importResult struct {
+	DryRun   bool
+	Items    []*importItem
+	Created  int
+	Replaced int
+	Skipped  int
+}
+
+// planImport works out what to do with each archived snippet. Existing
+// maps the titles of the user's snippets to their IDs, and orgs the slugs
+// of the organisations they're a member of to the organisations.
+// Snippets for other organisations are imported as private ones.
+func planImport(userID int, archived []*archivedSnippet, existing map[string]int, orgs map[string]*models.Organisation, conflict string, now time.Time) []*importItem {
+	items := make([]*importItem, 0, len(archived))
+	for _, as := range archived {
+		s := &models.Snippet{
+			UserID:  userID,
+			Title:   strings.TrimSpace(as.Title),
+			Content: as.Content,
+			Created: as.Created,
+			Expires: as.Expires,
+		}
+		if s.Title == "" {
+			s.Title = "Untitled"
+		}
+		if r := []rune(s.Title); len(r) > 100 {
+			s.Title = string(r[:100])
+		}
+		if s.Created.IsZero() || s.Created.After(now) {
+			s.Created = now
+		}
+		if !s.Expires.After(now) || s.Expires.After(now.Add(importLifetime)) {
+			s.Expires = now.Add(importLifetime)
+		}
+
+		it := &importItem{Title: s.Title, Visibility: "Everyone", Action: importCreate, snippet: s}
+		switch as.Visibility {
+		case visibilityPublic, "":
+		case visibilityOrg:
+			if org, ok := orgs[as.Org]; ok {
+				s.OrgID = org.ID
+				it.Visibility = "Members of " + org.Name
+				break
+			}
+			s.Private = true
+			it.Visibility = "Only you"
+			it.Note = fmt.Sprintf("You aren't a member of %q, so it will be private.", as.Org)
+		default:
+			s.Private = true
+			it.Visibility = "Only you"
+		}
+
+		if strings.TrimSpace(s.Content) == "" {
+			it.Action = importSkip
+			it.Note = "It has no content."
+		} else if id, ok := existing[s.Title]; ok {
+			it.ExistingID = id
+			switch conflict {
+			case conflictReplace:
+				it.Action = importReplace
+				it.Note = "Its content will replace that of the snippet with the same title."
+			case conflictKeep:
+				it.Note = "You already have a snippet with this title, which will be kept."
+			default:
+				it.Action = importSkip
+				it.Note = "You already have a snippet with this title."
+			}
+		}
+		items = append(items, it)
+	}
+	return items
+}
+
+func (app *application) importForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "import.page.tmpl", &templateData{
+		Form: forms.New(url.Values{"conflict": {conflictSkip}, "dry_run": {"true"}}),
+	})
+}
+
+// importSnippets imports the snippets from an uploaded archive or gist.
+// In a dry run it only shows what it would do.
+func (app *application) importSnippets(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("conflict")
+	form.PermittedValues("conflict", conflictSkip, conflictKeep, conflictReplace)
+
+	var archived []*archivedSnippet
+	file, _, err := r.FormFile("file")
+	if err == http.ErrMissingFile {
+		form.Errors.Add("file", "Choose a file to import")
+	} else if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	} else {
+		defer file.Close()
+		data, err := io.ReadAll(io.LimitReader(file, maxImportSize+1))
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		switch {
+		case len(data) > maxImportSize:
+			form.Errors.Add("file", "The file is too large")
+		default:
+			archived, err = readArchive(data)
+			if err != nil {
+				form.Errors.Add("file", "This file can't be imported: "+err.Error())
+			} else if len(archived) > maxImportSnippets {
+				form.Errors.Add("file", fmt.Sprintf("At most %d snippets can be imported at once", maxImportSnippets))
+			} else if len(archived) == 0 {
+				form.Errors.Add("file", "There are no snippets in this file")
+			}
+		}
+	}
+	if !form.Valid() {
+		app.render(w, r, "import.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	existing := map[string]int{}
+	err = app.snippets.EachByUser(user.ID, func(s *models.Snippet) error {
+		existing[s.Title] = s.ID
+		return nil
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	memberships, err := app.orgs.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	orgs := map[string]*models.Organisation{}
+	for _, org := range memberships {
+		orgs[org.Slug] = org
+	}
+
+	result := &importResult{
+		DryRun: form.Get("dry_run") != "",
+		Items:  planImport(user.ID, archived, existing, orgs, form.Get("conflict"), time.Now().UTC().Truncate(time.Second)),
+	}
+	for _, it := range result.Items {
+		switch it.Action {
+		case importCreate:
+			result.Created++
+		case importReplace:
+			result.Replaced++
+		default:
+			result.Skipped++
+		}
+	}
+
+	if !result.DryRun {
+		// Everything is saved in one go, and the webhooks only hear about
+		// it once it has been.
+		var saved []*models.Snippet
+		for _, it := range result.Items {
+			switch it.Action {
+			case importCreate:
+				saved = append(saved, it.snippet)
+			case importReplace:
+				it.snippet.ID = it.ExistingID
+				saved = append(saved, it.snippet)
+			}
+		}
+		err = app.snippets.Import(saved)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		for _, it := range result.Items {
+			switch it.Action {
+			case importCreate:
+				it.ID = it.snippet.ID
+				app.snippetEventByID(models.EventSnippetCreated, it.ID)
+			case importReplace:
+				it.ID = it.snippet.ID
+				app.snippetEventByID(models.EventSnippetUpdated, it.ID)
+			}
+		}
+		app.audit(r, &models.AuditEvent{
+			Action:  models.AuditSnippetImport,
+			Details: fmt.Sprintf("%d created, %d replaced, %d skipped", result.Created, result.Replaced, result.Skipped),
+		})
+	}
+
+	app.render(w, r, "import.page.tmpl", &templateData{
+		Form:   form,
+		Import: result,
+	})
+}
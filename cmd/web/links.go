@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// linkLifetimes are the choices of how long a share link lasts, in hours,
+// offered when one is created.
+var linkLifetimes = []string{"1", "24", "168", "720"}
+
+// maxLinkViews is the largest view limit a share link can be given.
+const maxLinkViews = 10000
+
+// shareLink is a share link as shown to the snippet's author. Its URL is
+// only known just after it's created, since the secret in it isn't kept.
+type shareLink struct {
+	*models.ShareLink
+	URL    string
+	Status string
+}
+
+// signLink returns the token for the share link with the given ID, expiry
+// and secret: all three, and an HMAC-SHA256 of them keyed with the
+// server's share key. The signature is enough to tell forged or expired
+// links apart without a trip to the database, which checks the secret and
+// has the final say.
+func signLink(key []byte, id int, expires time.Time, secret string) string {
+	payload := fmt.Sprintf("%d.%d.%s", id, expires.Unix(), secret)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyLink checks a share link token's signature and expiry, and returns
+// the ID and secret of the link.
+func verifyLink(key []byte, token string, now time.Time) (int, string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[2] == "" {
+		return 0, "", false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return 0, "", false
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0] + "." + parts[1] + "." + parts[2]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return 0, "", false
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id < 1 {
+		return 0, "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !now.Before(time.Unix(expires, 0)) {
+		return 0, "", false
+	}
+
+	return id, parts[2], true
+}
+
+// The shareLinks helper returns a snippet's share links, for its author.
+// A link which has just been created comes with its URL.
+func (app *application) shareLinks(r *http.Request, s *models.Snippet) ([]*shareLink, error) {
+	links, err := app.links.ForSnippet(s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	token := app.session.PopString(r, "shareLink")
+	newID, _, _ := verifyLink(app.shareKey, token, time.Now())
+
+	now := time.Now()
+	shown := make([]*shareLink, len(links))
+	for i, l := range links {
+		sl := &shareLink{ShareLink: l}
+		if l.ID == newID {
+			sl.URL = absoluteURL(r, "/s/"+token)
+		}
+		switch {
+		case l.Revoked:
+			sl.Status = "revoked"
+		case !now.Before(l.Expires):
+			sl.Status = "expired"
+		case l.MaxViews > 0 && l.Views >= l.MaxViews:
+			sl.Status = "used up"
+		default:
+			sl.Status = "active"
+		}
+		shown[i] = sl
+	}
+	return shown, nil
+}
+
+func (app *application) createShareLink(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// Anyone can already see public snippets, so links are only for the
+	// ones which aren't.
+	if !s.Private && s.OrgID == 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("link_expires")
+	form.PermittedValues("link_expires", linkLifetimes...)
+	maxViews := 0
+	if v := form.Get("max_views"); v != "" {
+		maxViews, err = strconv.Atoi(v)
+		if err != nil || maxViews < 1 || maxViews > maxLinkViews {
+			form.Errors.Add("max_views", fmt.Sprintf("Enter a number from 1 to %d, or leave blank for no limit", maxLinkViews))
+		}
+	}
+	if !form.Valid() {
+		app.renderSnippet(w, r, s, form)
+		return
+	}
+
+	hours, _ := strconv.Atoi(form.Get("link_expires"))
+	l, secret, err := app.links.Insert(s.ID, app.authenticatedUser(r).ID, time.Now().Add(time.Duration(hours)*time.Hour), maxViews)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditLinkCreate, SnippetID: s.ID, Details: fmt.Sprintf("link #%d", l.ID)})
+
+	// The link's secret isn't stored, so this is the only time its URL can
+	// be shown.
+	app.session.Put(r, "shareLink", signLink(app.shareKey, l.ID, l.Expires, secret))
+	app.session.Put(r, "flash", "Your share link has been created. Copy it from the list of share links now, as it won't be shown again.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", s.ID), http.StatusSeeOther)
+}
+
+func (app *application) revokeShareLink(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":link"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.links.Revoke(s.ID, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditLinkRevoke, SnippetID: s.ID, Details: fmt.Sprintf("link #%d", id)})
+
+	app.session.Put(r, "flash", "The share link has been revoked.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", s.ID), http.StatusSeeOther)
+}
+
+// followShareLink shows the snippet a share link is for, to anyone with
+// the link, counting the view against the link's limit.
+func (app *application) followShareLink(w http.ResponseWriter, r *http.Request) {
+	// The URL is what grants access, so keep it out of Referer headers,
+	// caches and search engines.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	id, secret, ok := verifyLink(app.shareKey, r.URL.Query().Get(":token"), time.Now())
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	snippetID, err := app.links.Use(id, secret)
+	if err == models.ErrInvalidToken {
+		app.clientError(w, http.StatusGone)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	v := app.viewer(r)
+	v.LinkSnippetID = snippetID
+	s, err := app.snippets.Get(snippetID, v)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err == models.ErrTakenDown {
+		app.clientError(w, http.StatusUnavailableForLegalReasons)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.views.Record(s.ID, r)
+
+	app.render(w, r, "link.page.tmpl", &templateData{
+		Lines:   snippetLines(s.Content, lineRange{}, nil),
+		Snippet: s,
+	})
+}
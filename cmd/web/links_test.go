@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyLink(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	token := signLink(key, 42, expires, "s3cret")
+
+	id, secret, ok := verifyLink(key, token, now)
+	if !ok || id != 42 || secret != "s3cret" {
+		t.Fatalf("want link 42 with its secret; got %d, %q, %v", id, secret, ok)
+	}
+
+	parts := strings.Split(token, ".")
+	tests := []struct {
+		name  string
+		key   []byte
+		token string
+		now   time.Time
+	}{
+		{"Expired", key, token, expires},
+		{"Wrong key", []byte("another key"), token, now},
+		{"Other link", key, "43." + parts[1] + "." + parts[2] + "." + parts[3], now},
+		{"Extended", key, parts[0] + ".9999999999." + parts[2] + "." + parts[3], now},
+		{"Other secret", key, parts[0] + "." + parts[1] + ".guess." + parts[3], now},
+		{"No secret", key, parts[0] + "." + parts[1] + ".." + parts[3], now},
+		{"Bad signature", key, parts[0] + "." + parts[1] + "." + parts[2] + ".AAAA", now},
+		{"Malformed", key, "42", now},
+		{"Empty", key, "", now},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if id, _, ok := verifyLink(tt.key, tt.token, tt.now); ok {
+				t.Errorf("want rejected; got link %d", id)
+			}
+		})
+	}
+}
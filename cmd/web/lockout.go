@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// An attemptStore keeps count of the consecutive failed logins for a key,
+// and when the last one was. Both the in-memory store below and
+// mysql.LoginAttemptModel satisfy it; the database store lets several
+// instances of the application share the counts.
+//
+// Reserve counts an attempt as a failure before it's made, unless allow
+// refuses it, and returns the new count; checking and counting happen
+// together, so attempts made at the same time can't all get in under the
+// limit. Failures from before since are forgotten first. Refund takes back
+// an attempt which didn't fail.
+type attemptStore interface {
+	Reserve(key string, at, since time.Time, allow func(failures int, last time.Time) bool) (int, bool, error)
+	Refund(key string) error
+	Reset(key string) error
+}
+
+// A memoryAttemptStore is an attemptStore which keeps its counts in memory.
+// Counts older than ttl are dropped from time to time so the map can't
+// grow without limit.
+type memoryAttemptStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]*attempts
+	pruned   time.Time
+}
+
+type attempts struct {
+	failures int
+	last     time.Time
+}
+
+func newMemoryAttemptStore(ttl time.Duration) *memoryAttemptStore {
+	return &memoryAttemptStore{ttl: ttl, attempts: map[string]*attempts{}}
+}
+
+func (s *memoryAttemptStore) Reserve(key string, at, since time.Time, allow func(int, time.Time) bool) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if at.Sub(s.pruned) > s.ttl {
+		for k, a := range s.attempts {
+			if at.Sub(a.last) > s.ttl {
+				delete(s.attempts, k)
+			}
+		}
+		s.pruned = at
+	}
+
+	a, ok := s.attempts[key]
+	if !ok || a.last.Before(since) {
+		a = &attempts{}
+	}
+	if !allow(a.failures, a.last) {
+		return a.failures, false, nil
+	}
+	a.failures++
+	a.last = at
+	s.attempts[key] = a
+	return a.failures, true, nil
+}
+
+func (s *memoryAttemptStore) Refund(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.attempts[key]; ok && a.failures > 0 {
+		a.failures--
+	}
+	return nil
+}
+
+func (s *memoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// A lockoutPolicy says how a key is slowed down as its failures mount up.
+// The first free failures cost nothing; after that each failure doubles
+// the wait before the next attempt is allowed, starting from delay. At
+// lockout failures the key is locked out for lockoutFor.
+type lockoutPolicy struct {
+	free       int
+	delay      time.Duration
+	lockout    int
+	lockoutFor time.Duration
+}
+
+// wait returns how long after the last failure the next attempt must wait.
+func (p lockoutPolicy) wait(failures int) time.Duration {
+	if failures >= p.lockout {
+		return p.lockoutFor
+	}
+	if failures < p.free {
+		return 0
+	}
+	d := time.Duration(float64(p.delay) * math.Pow(2, float64(failures-p.free)))
+	if d > p.lockoutFor {
+		d = p.lockoutFor
+	}
+	return d
+}
+
+var (
+	// Failures are counted against the email address tried, whether or
+	// not there is an account for it, so the responses don't reveal
+	// which addresses are registered.
+	accountPolicy = lockoutPolicy{free: 3, delay: time.Second, lockout: 10, lockoutFor: 15 * time.Minute}
+
+	// Many people can share an IP address, so it gets more leeway.
+	ipPolicy = lockoutPolicy{free: 20, delay: time.Second, lockout: 100, lockoutFor: time.Hour}
+)
+
+// Failure counts are forgotten this long after the last failure.
+const attemptTTL = 24 * time.Hour
+
+// A loginThrottle tracks failed logins per account and per IP address.
+type loginThrottle struct {
+	store attemptStore
+	now   func() time.Time
+}
+
+func newLoginThrottle(store attemptStore) *loginThrottle {
+	return &loginThrottle{store: store, now: time.Now}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(r *http.Request) string {
+	return "ip:" + remoteIP(r)
+}
+
+// Reserve counts a login for the email address from the request as a
+// failure against both the account and the IP address, before the
+// password is checked. If there have been too many failures recently
+// nothing is counted, and it returns how much longer the login has to
+// wait. Otherwise it reports whether the account is locked out should
+// this attempt fail.
+func (t *loginThrottle) Reserve(r *http.Request, email string) (time.Duration, bool, error) {
+	now := t.now()
+	since := now.Add(-attemptTTL)
+
+	var wait time.Duration
+	allow := func(p lockoutPolicy) func(int, time.Time) bool {
+		return func(failures int, last time.Time) bool {
+			wait = p.wait(failures) - now.Sub(last)
+			return wait <= 0
+		}
+	}
+
+	ip := ipKey(r)
+	_, ok, err := t.store.Reserve(ip, now, since, allow(ipPolicy))
+	if err != nil || !ok {
+		return wait, false, err
+	}
+	failures, ok, err := t.store.Reserve(accountKey(email), now, since, allow(accountPolicy))
+	if err != nil {
+		return 0, false, err
+	}
+	if !ok {
+		// The login isn't going ahead, so it doesn't count against the IP
+		// address either.
+		return wait, false, t.store.Refund(ip)
+	}
+	return 0, failures == accountPolicy.lockout, nil
+}
+
+// Succeed clears the failures against the account, including the login
+// which has just succeeded. Only that login is taken off the count against
+// the IP address, so an attacker can't reset it by logging in to an
+// account of their own.
+func (t *loginThrottle) Succeed(r *http.Request, email string) error {
+	if err := t.store.Refund(ipKey(r)); err != nil {
+		return err
+	}
+	return t.Reset(email)
+}
+
+// Reset clears the failures against the account.
+func (t *loginThrottle) Reset(email string) error {
+	return t.store.Reset(accountKey(email))
+}
+
+// The notifyLockout helper emails the owner of an account, if there is one,
+// to let them know it has been locked after too many failed logins.
+func (app *application) notifyLockout(r *http.Request, email string) {
+	user, err := app.users.GetByEmail(email)
+	if err == models.ErrNoRecord {
+		return
+	} else if err != nil {
+		app.errorLog.Print(err)
+		return
+	}
+
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Your Snippetbox account has been locked",
+		Body: fmt.Sprintf(`Hi %s,
+
+There have been %d failed attempts to log in to your Snippetbox account, so
+logging in has been blocked for the next %d minutes.
+
+If this was you, you can reset your password here:
+
+%s
+
+If it wasn't, someone may be trying to guess your password. Make sure it's
+one you don't use anywhere else.
+`, user.Name, accountPolicy.lockout, int(accountPolicy.lockoutFor.Minutes()), absoluteURL(r, "/user/password/forgot")),
+	})
+}
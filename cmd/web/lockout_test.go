@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockoutPolicyWait(t *testing.T) {
+	p := lockoutPolicy{free: 3, delay: time.Second, lockout: 10, lockoutFor: 15 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{6, 8 * time.Second},
+		{9, 64 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := p.wait(tt.failures); got != tt.want {
+			t.Errorf("%d failures: want %v; got %v", tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	lt := newLoginThrottle(newMemoryAttemptStore(attemptTTL))
+	lt.now = func() time.Time { return now }
+
+	r := httptest.NewRequest("POST", "/user/login", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	reserve := func(email string) (time.Duration, bool) {
+		t.Helper()
+		wait, lockedOut, err := lt.Reserve(r, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wait, lockedOut
+	}
+
+	for i := 1; i <= accountPolicy.lockout; i++ {
+		wait, lockedOut := reserve("Alice@Example.com")
+		if wait > 0 {
+			t.Fatalf("attempt %d: want no wait; got %v", i, wait)
+		}
+		if lockedOut != (i == accountPolicy.lockout) {
+			t.Errorf("attempt %d: want locked out %v; got %v", i, i == accountPolicy.lockout, lockedOut)
+		}
+		// Wait out any backoff before the next attempt.
+		if i < accountPolicy.lockout {
+			now = now.Add(accountPolicy.wait(i))
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if wait, _ := reserve("alice@example.com"); wait != accountPolicy.lockoutFor-time.Minute {
+		t.Errorf("want the account locked for %v; got %v", accountPolicy.lockoutFor-time.Minute, wait)
+	}
+	if wait, _ := reserve("bob@example.com"); wait > 0 {
+		t.Errorf("want other accounts unaffected; got wait %v", wait)
+	}
+
+	now = now.Add(accountPolicy.lockoutFor - time.Minute)
+	if wait, _ := reserve("alice@example.com"); wait > 0 {
+		t.Errorf("want the lockout over; got wait %v", wait)
+	}
+
+	if err := lt.Succeed(r, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < accountPolicy.free; i++ {
+		if wait, _ := reserve("alice@example.com"); wait > 0 {
+			t.Errorf("want the count reset by a successful login; got wait %v", wait)
+		}
+	}
+}
+
+func TestLoginThrottleConcurrent(t *testing.T) {
+	lt := newLoginThrottle(newMemoryAttemptStore(attemptTTL))
+	now := time.Now()
+	lt.now = func() time.Time { return now }
+
+	r := httptest.NewRequest("POST", "/user/login", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	// Logins made at the same time are counted as they're let through, so
+	// only the free ones get to check a password.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 2*accountPolicy.lockout; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, _, err := lt.Reserve(r, "alice@example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait <= 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != accountPolicy.free {
+		t.Errorf("want %d logins allowed; got %d", accountPolicy.free, allowed)
+	}
+}
+
+func TestLoginThrottlePerIP(t *testing.T) {
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	lt := newLoginThrottle(newMemoryAttemptStore(attemptTTL))
+	lt.now = func() time.Time { return now }
+
+	r := httptest.NewRequest("POST", "/user/login", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	// Successful logins don't count against the IP address.
+	for i := 0; i < 2*ipPolicy.free; i++ {
+		if _, _, err := lt.Reserve(r, "me@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if err := lt.Succeed(r, "me@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Spread the failures over many accounts, so only the IP address
+	// builds up a count.
+	for i := 0; i < ipPolicy.free; i++ {
+		if _, _, err := lt.Reserve(r, string(rune('a'+i))+"@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wait, _, err := lt.Reserve(r, "new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != ipPolicy.delay {
+		t.Errorf("want wait %v; got %v", ipPolicy.delay, wait)
+	}
+
+	other := httptest.NewRequest("POST", "/user/login", nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+	wait, _, err = lt.Reserve(other, "new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait > 0 {
+		t.Errorf("want other IP addresses unaffected; got wait %v", wait)
+	}
+}
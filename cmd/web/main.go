@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
 	"flag"
@@ -8,9 +9,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
 	"github.com/ardianeffendi/snippetbox/pkg/models/mysql"
+	"github.com/ardianeffendi/snippetbox/pkg/oidc"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/golangcollege/sessions"
 )
@@ -18,30 +24,101 @@ import (
 type contextKey string
 
 var contextKeyUser = contextKey("user")
+var contextKeySession = contextKey("session")
 
 type application struct {
-	errorLog      *log.Logger
-	infoLog       *log.Logger
-	session       *sessions.Session
-	snippets      *mysql.SnippetModel
-	templateCache map[string]*template.Template
-	users         *mysql.UserModel
+	auditLog         *mysql.AuditModel
+	comments         *mysql.CommentModel
+	credentials      *mysql.CredentialModel
+	errorLog         *log.Logger
+	identities       *mysql.IdentityModel
+	infoLog          *log.Logger
+	links            *mysql.ShareLinkModel
+	loginThrottle    *loginThrottle
+	mailer           mailer.Mailer
+	oidc             *oidc.Provider
+	oidcLinkEmail    bool
+	orgs             *mysql.OrgModel
+	remember         *mysql.RememberTokenModel
+	reportLimiter    *rateLimiter
+	reports          *mysql.ReportModel
+	resendLimiter    *rateLimiter
+	resetLimiter     *rateLimiter
+	resetIPLimiter   *rateLimiter
+	session          *sessions.Session
+	sessions         sessionStore
+	shareKey         []byte
+	shares           *mysql.ShareModel
+	snippets         *mysql.SnippetModel
+	stars            *mysql.StarModel
+	stats            *mysql.StatsModel
+	templateCache    map[string]*template.Template
+	tokens           *mysql.TokenModel
+	twoFactorLimiter *rateLimiter
+	users            *mysql.UserModel
+	views            *viewRecorder
+	viewStats        *mysql.ViewModel
+	webhookQueue     *webhookDispatcher
+	webhooks         *mysql.WebhookModel
 }
 
 func main() {
+	// Subcommands, like "web create-admin", are run instead of the server.
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	// Define a new command-line flag with name 'addr', a default value of ":4000"
 	// and some short help text explaining what the flag controls. the value of the
 	// flag will be stored in the addr variable at runtime.
 	addr := flag.String("addr", ":4000", "HTTP network address")
 
 	// Define a new command-line flag for the MySQL DSN (Data Source Name) string.
-	dsn := flag.String("dsn", "web:tokyodome@/snippetbox?parseTime=true", "MySQL data source name")
+	dsn := flag.String("dsn", defaultDSN, "MySQL data source name")
 
 	// Define a new command-line flag for the session secret (a random key which
 	// will be used to encrypt and authenticate session cookies). It should be 32
 	// bytes long.
 	secret := flag.String("secret", "s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge", "Secret key")
 
+	// Define a new command-line flag for the key which share links are
+	// signed with. It must be a random string of at least 32 bytes, and has
+	// no default so that no two installations share one. Changing it stops
+	// every share link which has been handed out from working.
+	shareKey := flag.String("share-key", "", "Secret key for signing share links (required, at least 32 bytes)")
+
+	// Define the command-line flags for sending email. If no SMTP server is
+	// given, emails are written to the -mail-log file instead ("-" meaning
+	// stdout), which is all that's needed in development.
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server address (host:port)")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	mailFrom := flag.String("mail-from", "Snippetbox <no-reply@snippetbox.local>", "Sender of outgoing email")
+	mailLog := flag.String("mail-log", "-", "File to write emails to when no SMTP server is set")
+
+	// Define the command-line flag choosing where failed logins are counted.
+	// Use "mysql" when running more than one instance of the application, so
+	// they all see the same counts.
+	loginStore := flag.String("login-attempts", "memory", `Where to count failed logins ("memory" or "mysql")`)
+
+	// Define the command-line flag choosing where logged in sessions are
+	// kept. Use "mysql" when running more than one instance of the
+	// application, or to keep users logged in across restarts.
+	sessionStoreName := flag.String("session-store", "memory", `Where to keep logged in sessions ("memory" or "mysql")`)
+
+	// Define the command-line flags for single sign-on with an OpenID Connect
+	// provider. It's turned on by giving the provider's issuer URL. The
+	// provider must allow https://<host>/user/login/sso/callback as a
+	// redirect URL for the client.
+	oidcIssuer := flag.String("oidc-issuer", "", "OpenID Connect issuer URL")
+	oidcClientID := flag.String("oidc-client-id", "", "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", "", "OpenID Connect client secret")
+	oidcLinkEmail := flag.Bool("oidc-link-email", false, "Link single sign-on accounts to existing users with the same verified email address")
+
+	// Webhooks can't be sent to addresses on the server's own network
+	// unless this is set, which is mostly useful in development.
+	webhookPrivate := flag.Bool("webhook-private", false, "Allow webhooks to private and loopback addresses")
+
 	// Importantly, we use the flag.Parse() function to parse the command-line flag.
 	// This reads in the command-line flag value and assigns it to the addr
 	// variable. You need to call this *before* you use the addr variable
@@ -61,6 +138,11 @@ func main() {
 	// file name and line number.
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
+	// Refuse to start without a share link key of our own.
+	if len(*shareKey) < 32 {
+		errorLog.Fatal("-share-key must be set to a random string of at least 32 bytes")
+	}
+
 	// To keep the main() function tidy, the code for creating a connection pool
 	// is defined into a separate openDB() function below. The DSN from the command-line
 	// flag is then passed to openDB().
@@ -73,6 +155,54 @@ func main() {
 	// before the main() function exits.
 	defer db.Close()
 
+	// Choose how outgoing email is delivered.
+	var m mailer.Mailer
+	if *smtpAddr != "" {
+		m = &mailer.SMTP{Addr: *smtpAddr, Username: *smtpUsername, Password: *smtpPassword, From: *mailFrom}
+	} else if *mailLog == "-" {
+		m = mailer.NewLog(os.Stdout, *mailFrom)
+	} else {
+		f, err := os.OpenFile(*mailLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		defer f.Close()
+		m = mailer.NewLog(f, *mailFrom)
+	}
+
+	// Fetch the OpenID provider's configuration, if single sign-on is on.
+	var provider *oidc.Provider
+	if *oidcIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err = oidc.Discover(ctx, *oidcIssuer, *oidcClientID, *oidcClientSecret)
+		cancel()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
+	// Choose where failed logins are counted.
+	var attempts attemptStore
+	switch *loginStore {
+	case "memory":
+		attempts = newMemoryAttemptStore(attemptTTL)
+	case "mysql":
+		attempts = &mysql.LoginAttemptModel{DB: db}
+	default:
+		errorLog.Fatalf("unknown -login-attempts store %q", *loginStore)
+	}
+
+	// Choose where logged in sessions are kept.
+	var sessionStore sessionStore
+	switch *sessionStoreName {
+	case "memory":
+		sessionStore = newMemorySessionStore()
+	case "mysql":
+		sessionStore = &mysql.SessionModel{DB: db}
+	default:
+		errorLog.Fatalf("unknown -session-store %q", *sessionStoreName)
+	}
+
 	// Initialize a new template cache
 	templateCache, err := newTemplateCache("./ui/html/")
 	if err != nil {
@@ -88,14 +218,56 @@ func main() {
 
 	// Initialize a new instance of application containing the dependencies.
 	app := &application{
-		errorLog:      errorLog,
-		infoLog:       infoLog,
-		session:       session,
-		snippets:      &mysql.SnippetModel{DB: db},
-		templateCache: templateCache,
-		users:         &mysql.UserModel{DB: db},
+		auditLog:         &mysql.AuditModel{DB: db},
+		comments:         &mysql.CommentModel{DB: db},
+		credentials:      &mysql.CredentialModel{DB: db},
+		errorLog:         errorLog,
+		identities:       &mysql.IdentityModel{DB: db},
+		infoLog:          infoLog,
+		links:            &mysql.ShareLinkModel{DB: db},
+		loginThrottle:    newLoginThrottle(attempts),
+		mailer:           m,
+		oidc:             provider,
+		oidcLinkEmail:    *oidcLinkEmail,
+		orgs:             &mysql.OrgModel{DB: db},
+		remember:         &mysql.RememberTokenModel{DB: db},
+		reportLimiter:    newRateLimiter(10, time.Hour),
+		reports:          &mysql.ReportModel{DB: db},
+		resendLimiter:    newRateLimiter(3, time.Hour),
+		resetLimiter:     newRateLimiter(3, time.Hour),
+		resetIPLimiter:   newRateLimiter(20, time.Hour),
+		session:          session,
+		sessions:         sessionStore,
+		shareKey:         []byte(*shareKey),
+		shares:           &mysql.ShareModel{DB: db},
+		snippets:         &mysql.SnippetModel{DB: db},
+		stars:            &mysql.StarModel{DB: db},
+		stats:            &mysql.StatsModel{DB: db},
+		templateCache:    templateCache,
+		tokens:           &mysql.TokenModel{DB: db},
+		twoFactorLimiter: newRateLimiter(5, twoFactorTimeout),
+		users:            &mysql.UserModel{DB: db},
+		viewStats:        &mysql.ViewModel{DB: db},
+		webhooks:         &mysql.WebhookModel{DB: db},
 	}
 
+	// Snippet views are buffered in memory and written to the database in
+	// batches by a background goroutine.
+	app.views = newViewRecorder(app.viewStats, errorLog)
+	go app.views.Run(time.Minute)
+
+	// Snippet events are queued in the database and sent to webhooks by a
+	// background goroutine.
+	app.webhookQueue = newWebhookDispatcher(app.webhooks, newWebhookClient(*webhookPrivate), errorLog)
+	go app.webhookQueue.Run(time.Minute)
+
+	// Expired sessions are cleared out of the store now and then.
+	go app.cleanSessions(time.Hour)
+
+	// Accounts are deleted once the grace period after their users asked
+	// for it is over.
+	go app.eraseAccounts(time.Hour)
+
 	// Initialise a tls.Config struct to hold the non-defaults TLS settings
 	tlsConfig := &tls.Config{
 		PreferServerCipherSuites: true,
@@ -112,9 +284,31 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	// On SIGINT or SIGTERM, stop taking requests and let the ones in
+	// flight finish, so that the views they record are flushed below.
+	shutdown := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
 	infoLog.Printf("Starting server on %s", *addr)
 	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	if err == http.ErrServerClosed {
+		err = <-shutdown
+	}
+
+	// Write out the views which are still buffered before exiting.
+	app.views.Flush()
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	infoLog.Print("Server stopped")
 }
 
 // The openDB() function wraps sql.Open() and returns a sql.DB connection pool
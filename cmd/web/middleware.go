@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/ardianeffendi/snippetbox/pkg/models"
 	"github.com/justinas/nosurf"
@@ -11,20 +12,37 @@ import (
 
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if a userID value exists in the session. If this *isn't present*
-		// then call the next handler in the chain as normal.
-		exists := app.session.Exists(r, "userID")
-		if !exists {
+		// Look up the server-side session. If there isn't one, try to
+		// start one from a "remember me" token, and if that doesn't work
+		// either then call the next handler in the chain as normal.
+		sess, err := app.lookupSession(r)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if sess == nil {
+			err = app.restoreSession(w, r)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			sess, err = app.lookupSession(r)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+		}
+		if sess == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Fetch the details of the current user from the database. If
-		// no matching record is found, remove the (invalid) userID from
+		// no matching record is found, or the user has been disabled, remove the (invalid) sessionID from
 		// their session and call the next handler in the chain as normal.
-		user, err := app.users.Get(app.session.GetInt(r, "userID"))
-		if err == models.ErrNoRecord {
-			app.session.Remove(r, "userID")
+		user, err := app.users.Get(sess.UserID)
+		if err == models.ErrNoRecord || (err == nil && user.Disabled) {
+			app.session.Remove(r, "sessionID")
 			next.ServeHTTP(w, r)
 			return
 		} else if err != nil {
@@ -32,11 +50,22 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Keep the last seen time on the sessions page roughly up to date,
+		// without writing to the store on every request.
+		if time.Since(sess.LastSeen) > touchInterval || sess.IP != remoteIP(r) {
+			err = app.sessions.Touch(sess.ID, time.Now(), remoteIP(r))
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+		}
+
 		// Otherwise, we know the request is coming from a valid authenticated
 		// (logged in) user. We create a new copy of the request with user information
 		// added to the request context, and call the next handler in the chain
 		// *using this new copy of the request*.
 		ctx := context.WithValue(r.Context(), contextKeyUser, user)
+		ctx = context.WithValue(ctx, contextKeySession, sess.ID)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -57,6 +86,46 @@ func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler
 	})
 }
 
+// The requireRole middleware only lets through users with the role (or a
+// more powerful one). Anyone else who is logged in gets a 403 Forbidden.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// If the user is not authenticated, redirect them to the login
+			// page, just like requireAuthenticatedUser.
+			user := app.authenticatedUser(r)
+			if user == nil {
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			if !user.HasRole(role) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			// Otherwise call the next handler in the chain
+			w.Header().Add("Cache-Control", "no-store")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (app *application) requireVerifiedUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// This must come after requireAuthenticatedUser in the chain. If the
+		// user hasn't verified their email address yet, send them to the page
+		// which explains how to.
+		if !app.authenticatedUser(r).Verified {
+			app.session.Put(r, "flash", "Please verify your email address before creating snippets.")
+			http.Redirect(w, r, "/user/verification", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Create a deferred function (which will always be run in the event
@@ -94,6 +163,17 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// maxBytes limits the size of request bodies. It has to come before noSurf,
+// which reads the form to find the CSRF token.
+func maxBytes(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
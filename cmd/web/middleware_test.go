@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -62,3 +65,37 @@ func TestSecureHeaders(t *testing.T) {
 		t.Errorf("want body to equal %q", "OK")
 	}
 }
+
+func TestRequireRole(t *testing.T) {
+	app := &application{}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		wantCode int
+	}{
+		{"Anonymous", nil, http.StatusSeeOther},
+		{"User", &models.User{ID: 1, Role: models.RoleUser}, http.StatusForbidden},
+		{"Moderator", &models.User{ID: 1, Role: models.RoleModerator}, http.StatusOK},
+		{"Admin", &models.User{ID: 1, Role: models.RoleAdmin}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/admin/snippets", nil)
+			if tt.user != nil {
+				r = r.WithContext(context.WithValue(r.Context(), contextKeyUser, tt.user))
+			}
+
+			app.requireRole(models.RoleModerator)(next).ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rr.Code)
+			}
+		})
+	}
+}
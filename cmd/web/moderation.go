@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// reasonLabels are the descriptions of the report reasons shown to users.
+var reasonLabels = map[string]string{
+	models.ReasonSpam:      "Spam or advertising",
+	models.ReasonAbuse:     "Harassment or hate speech",
+	models.ReasonMalware:   "Malware or phishing",
+	models.ReasonPersonal:  "Someone's personal information",
+	models.ReasonCopyright: "Copyright infringement",
+	models.ReasonIllegal:   "Other illegal content",
+	models.ReasonOther:     "Something else",
+}
+
+// The reasonLabel template function describes a report reason.
+func reasonLabel(reason string) string {
+	if label, ok := reasonLabels[reason]; ok {
+		return label
+	}
+	return reason
+}
+
+func (app *application) reportSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	s, err := app.snippets.Get(id, app.viewer(r))
+	if err == models.ErrNoRecord || err == models.ErrTakenDown {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("reason")
+	form.PermittedValues("reason", models.ReportReasons...)
+	form.MaxLength("details", 1000)
+	if form.Get("reason") == models.ReasonOther {
+		form.Required("details")
+	}
+	if !form.Valid() {
+		app.renderSnippet(w, r, s, form)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	if !app.reportLimiter.Allow(strconv.Itoa(user.ID)) {
+		form.Errors.Add("reason", "You've sent a lot of reports recently. Please try again later.")
+		app.renderSnippet(w, r, s, form)
+		return
+	}
+
+	added, err := app.reports.Insert(s.ID, user.ID, form.Get("reason"), form.Get("details"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if added {
+		app.session.Put(r, "flash", "Thanks for your report. A moderator will look at it soon.")
+	} else {
+		app.session.Put(r, "flash", "You've already reported this snippet. A moderator will look at it soon.")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", s.ID), http.StatusSeeOther)
+}
+
+func (app *application) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	open, err := app.reports.Open()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	decided, err := app.reports.Decided(20)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "moderation.page.tmpl", &templateData{
+		Reports:        open,
+		DecidedReports: decided,
+		Form:           forms.New(nil),
+	})
+}
+
+func (app *application) moderateReport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	report, err := app.reports.Get(id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if report.Status != models.ReportOpen {
+		app.session.Put(r, "flash", "That report has already been dealt with.")
+		http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+		return
+	}
+
+	// The snippet may have expired since it was reported, in which case
+	// there's nobody to tell and nothing to hide.
+	s, err := app.snippets.Get(report.SnippetID, app.moderatorViewer(r))
+	if err == models.ErrNoRecord {
+		s = nil
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	note := r.PostForm.Get("note")
+	var status string
+	switch r.PostForm.Get("action") {
+	case "hide":
+		status = models.ReportHidden
+		if s != nil {
+			err = app.snippets.SetHidden(s.ID, true, report.Reason)
+		}
+	case "delete":
+		status = models.ReportDeleted
+		err = app.snippets.Delete(report.SnippetID)
+	case "dismiss":
+		status = models.ReportDismissed
+	default:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.reports.Decide(report.SnippetID, app.authenticatedUser(r).ID, status, note)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	details := fmt.Sprintf("report #%d: %s", report.ID, report.Reason)
+	switch status {
+	case models.ReportHidden:
+		app.audit(r, &models.AuditEvent{Action: models.AuditSnippetHide, SnippetID: report.SnippetID, Details: details})
+		if s != nil {
+			s.Hidden, s.HiddenReason = true, report.Reason
+			app.snippetEvent(models.EventSnippetUpdated, s)
+		}
+	case models.ReportDeleted:
+		app.audit(r, &models.AuditEvent{Action: models.AuditSnippetDelete, SnippetID: report.SnippetID, Details: details})
+		if s != nil {
+			app.snippetEvent(models.EventSnippetDeleted, s)
+		}
+	}
+
+	if s != nil && status != models.ReportDismissed {
+		app.notifyTakedown(r, s, status, report.Reason, note)
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("The reports on snippet #%d have been closed (%s).", report.SnippetID, status))
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+// The notifyTakedown helper emails the author of a snippet which has been
+// hidden or deleted by a moderator.
+func (app *application) notifyTakedown(r *http.Request, s *models.Snippet, status, reason, note string) {
+	if s.UserID == 0 {
+		return
+	}
+	author, err := app.users.Get(s.UserID)
+	if err == models.ErrNoRecord {
+		return
+	} else if err != nil {
+		app.errorLog.Print(err)
+		return
+	}
+
+	what := fmt.Sprintf("has been hidden. You can still see it at %s, but nobody else can.", absoluteURL(r, fmt.Sprintf("/snippet/%d", s.ID)))
+	if status == models.ReportDeleted {
+		what = "has been deleted."
+	}
+	if note != "" {
+		note = "\nThe moderator added:\n\n" + note + "\n"
+	}
+
+	app.sendMail(&mailer.Message{
+		To:      author.Email,
+		Subject: fmt.Sprintf("Your snippet %q has been %s", s.Title, status),
+		Body: fmt.Sprintf(`Hi %s,
+
+Your snippet %q was reported for: %s. A moderator has reviewed
+it, and it %s
+%s`, author.Name, s.Title, reasonLabel(reason), what, note),
+	})
+}
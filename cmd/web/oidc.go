@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+	"github.com/ardianeffendi/snippetbox/pkg/oidc"
+)
+
+// The single sign-on flow sends the user to the OpenID provider with a
+// random state, nonce and PKCE code verifier, all of which are kept in the
+// session until the provider redirects back to the callback.
+
+func (app *application) ssoRedirectURL(r *http.Request) string {
+	return absoluteURL(r, "/user/login/sso/callback")
+}
+
+func (app *application) ssoLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.Random()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	app.session.Put(r, "oidcState", state)
+	app.session.Put(r, "oidcNonce", nonce)
+	app.session.Put(r, "oidcVerifier", verifier)
+
+	http.Redirect(w, r, app.oidc.AuthCodeURL(app.ssoRedirectURL(r), state, nonce, verifier), http.StatusFound)
+}
+
+func (app *application) ssoCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	// Take the values out of the session first, so that each login attempt
+	// can only be completed once.
+	state := app.session.PopString(r, "oidcState")
+	nonce := app.session.PopString(r, "oidcNonce")
+	verifier := app.session.PopString(r, "oidcVerifier")
+
+	failed := func(msg string) {
+		app.session.Put(r, "flash", msg)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	}
+
+	q := r.URL.Query()
+	if state == "" || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		failed("Your single sign-on login has expired. Please try again.")
+		return
+	}
+	if q.Get("error") != "" {
+		failed("Single sign-on was cancelled or refused.")
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), app.ssoRedirectURL(r), q.Get("code"), verifier, nonce)
+	if err != nil {
+		app.errorLog.Print(err)
+		failed("Single sign-on failed. Please try again.")
+		return
+	}
+
+	id, err := app.ssoUser(r, claims)
+	if err == models.ErrDuplicateEmail {
+		failed("An account with that email address already exists. Log in with your password instead.")
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.Disabled {
+		app.audit(r, &models.AuditEvent{Action: models.AuditLoginFailed, UserID: id, Details: "single sign-on to a disabled account"})
+		failed("This account has been disabled.")
+		return
+	}
+	if user.TwoFactor {
+		app.startTwoFactorLogin(w, r, id, false)
+		return
+	}
+
+	err = app.logIn(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditLogin, ActorID: id, UserID: id, Details: "single sign-on"})
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// The ssoUser helper returns the ID of the user who has logged in at the
+// provider. Accounts seen for the first time are linked to the existing
+// user with the same email address, if the provider has verified it and
+// -oidc-link-email is set, or otherwise get a new user created for them.
+// ErrDuplicateEmail is returned when there is a user with that email
+// address who can't be linked.
+func (app *application) ssoUser(r *http.Request, claims *oidc.Claims) (int, error) {
+	id, err := app.identities.Get(claims.Issuer, claims.Subject)
+	if err == nil {
+		return id, nil
+	} else if err != models.ErrNoRecord {
+		return 0, err
+	}
+
+	if claims.Email == "" {
+		return 0, fmt.Errorf("oidc: provider %s sent no email address for %s", claims.Issuer, claims.Subject)
+	}
+
+	existing, err := app.users.GetByEmail(claims.Email)
+	if err == nil {
+		if !app.oidcLinkEmail || !claims.EmailVerified {
+			return 0, models.ErrDuplicateEmail
+		}
+		id = existing.ID
+	} else if err == models.ErrNoRecord {
+		id, err = app.provisionUser(r, claims)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		return 0, err
+	}
+
+	err = app.identities.Insert(id, claims.Issuer, claims.Subject)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// The provisionUser helper creates a user for someone logging in with single
+// sign-on for the first time. They're given a random password, which they
+// can replace using the forgotten password page if they want to log in
+// without the provider.
+func (app *application) provisionUser(r *http.Request, claims *oidc.Claims) (int, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	if len([]rune(name)) > 255 {
+		name = string([]rune(name)[:255])
+	}
+
+	id, err := app.users.Insert(name, claims.Email, base64.StdEncoding.EncodeToString(b))
+	if err != nil {
+		return 0, err
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditSignup, ActorID: id, UserID: id, Email: claims.Email, Details: "single sign-on"})
+
+	if claims.EmailVerified {
+		err = app.users.SetVerified(id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
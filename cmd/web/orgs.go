@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// invitationTTL is how long an emailed invitation to join an organisation
+// can be used for.
+const invitationTTL = 7 * 24 * time.Hour
+
+// slugRX matches organisation slugs: lower case letters, digits and
+// hyphens, not starting or ending with a hyphen.
+var slugRX = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]*[a-z0-9])?$`)
+
+// reservedSlugs would clash with the other pages under /org/.
+var reservedSlugs = map[string]bool{"invitation": true}
+
+// The isMember helper reports whether the user belongs to the
+// organisation. Errors are logged and count as not being a member.
+func (app *application) isMember(orgID, userID int) bool {
+	_, err := app.orgs.Role(orgID, userID)
+	if err != nil && err != models.ErrNoRecord {
+		app.errorLog.Print(err)
+	}
+	return err == nil
+}
+
+// The orgFromURL helper returns the organisation with the slug from the
+// URL, with Role set to the current user's role in it. Organisations are
+// private, so anyone who isn't a member gets a 404.
+func (app *application) orgFromURL(w http.ResponseWriter, r *http.Request) (*models.Organisation, bool) {
+	org, err := app.orgs.GetBySlug(r.URL.Query().Get(":slug"))
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return nil, false
+	} else if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	org.Role, err = app.orgs.Role(org.ID, app.authenticatedUser(r).ID)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return nil, false
+	} else if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	return org, true
+}
+
+// The orgOwner helper is orgFromURL for actions only owners can take.
+func (app *application) orgOwner(w http.ResponseWriter, r *http.Request) (*models.Organisation, bool) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+	if org.Role != models.OrgRoleOwner {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return org, true
+}
+
+func orgURL(org *models.Organisation) string {
+	return "/org/" + org.Slug
+}
+
+func (app *application) renderOrgs(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	orgs, err := app.orgs.ForUser(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "orgs.page.tmpl", &templateData{
+		Form:          form,
+		Organisations: orgs,
+	})
+}
+
+func (app *application) listOrgs(w http.ResponseWriter, r *http.Request) {
+	app.renderOrgs(w, r, forms.New(nil))
+}
+
+func (app *application) createOrg(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "slug")
+	form.MaxLength("name", 100)
+	form.MinLength("slug", 2)
+	form.MaxLength("slug", 40)
+	form.MatchesPattern("slug", slugRX)
+	if reservedSlugs[form.Get("slug")] {
+		form.Errors.Add("slug", "This address is reserved")
+	}
+	if !form.Valid() {
+		app.renderOrgs(w, r, form)
+		return
+	}
+
+	_, err = app.orgs.Insert(form.Get("name"), form.Get("slug"), app.authenticatedUser(r).ID)
+	if err == models.ErrDuplicateSlug {
+		form.Errors.Add("slug", "This address is already taken")
+		app.renderOrgs(w, r, form)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your organisation has been created. Invite your team below.")
+	http.Redirect(w, r, "/org/"+form.Get("slug"), http.StatusSeeOther)
+}
+
+func (app *application) renderOrg(w http.ResponseWriter, r *http.Request, org *models.Organisation, form *forms.Form) {
+	page, ok := pageNumber(r)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	snippets, err := app.snippets.ByOrg(org.ID, (page-1)*pageSize, pageSize+1, app.viewer(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	members, err := app.orgs.Members(org.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td := &templateData{
+		Form:         form,
+		Members:      members,
+		Organisation: org,
+		OrgRoles:     models.OrgRoles,
+		PrevPage:     page - 1,
+	}
+	if len(snippets) > pageSize {
+		snippets = snippets[:pageSize]
+		td.NextPage = page + 1
+	}
+	td.Snippets = snippets
+
+	if org.Role == models.OrgRoleOwner {
+		td.Invitations, err = app.orgs.Invitations(org.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.render(w, r, "org.page.tmpl", td)
+}
+
+func (app *application) showOrg(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return
+	}
+	app.renderOrg(w, r, org, forms.New(nil))
+}
+
+func (app *application) inviteMember(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "role")
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
+	form.PermittedValues("role", models.OrgRoles...)
+	if !form.Valid() {
+		app.renderOrg(w, r, org, form)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	token, err := app.orgs.Invite(org.ID, form.Get("email"), form.Get("role"), user.ID, invitationTTL)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	link := absoluteURL(r, "/org/invitation?"+url.Values{"token": {token}}.Encode())
+	app.sendMail(&mailer.Message{
+		To:      form.Get("email"),
+		Subject: fmt.Sprintf("Join %s on Snippetbox", org.Name),
+		Body: fmt.Sprintf(`Hi,
+
+%s has invited you to join %s on Snippetbox, where the team shares
+snippets which only its members can see. To accept, follow this link
+within a week and log in, or sign up with this email address:
+
+%s
+
+If you weren't expecting this, you can ignore this email.
+`, user.Name, org.Name, link),
+	})
+
+	app.session.Put(r, "flash", fmt.Sprintf("An invitation has been sent to %s.", form.Get("email")))
+	http.Redirect(w, r, orgURL(org), http.StatusSeeOther)
+}
+
+func (app *application) withdrawInvitation(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgOwner(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.orgs.DeleteInvitation(org.ID, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "The invitation has been withdrawn.")
+	http.Redirect(w, r, orgURL(org), http.StatusSeeOther)
+}
+
+// The orgMemberTarget helper returns the member with the user ID from the
+// URL, and whether they're the organisation's only owner, who can't be
+// removed or demoted or it would be left without one.
+func (app *application) orgMemberTarget(w http.ResponseWriter, r *http.Request, org *models.Organisation) (*models.Membership, bool, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false, false
+	}
+
+	members, err := app.orgs.Members(org.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false, false
+	}
+
+	var target *models.Membership
+	owners := 0
+	for _, mb := range members {
+		if mb.UserID == id {
+			target = mb
+		}
+		if mb.Role == models.OrgRoleOwner {
+			owners++
+		}
+	}
+	if target == nil {
+		app.notFound(w)
+		return nil, false, false
+	}
+
+	return target, target.Role == models.OrgRoleOwner && owners == 1, true
+}
+
+// The soleOwnerOf helper returns the organisations which the user is the
+// only owner of, and which would be left without one if they went.
+func (app *application) soleOwnerOf(userID int) ([]*models.Organisation, error) {
+	orgs, err := app.orgs.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sole []*models.Organisation
+	for _, org := range orgs {
+		if org.Role != models.OrgRoleOwner {
+			continue
+		}
+		members, err := app.orgs.Members(org.ID)
+		if err != nil {
+			return nil, err
+		}
+		owners := 0
+		for _, mb := range members {
+			if mb.Role == models.OrgRoleOwner {
+				owners++
+			}
+		}
+		if owners == 1 {
+			sole = append(sole, org)
+		}
+	}
+	return sole, nil
+}
+
+func (app *application) setMemberRole(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgOwner(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	role := r.PostForm.Get("role")
+	if role != models.OrgRoleOwner && role != models.OrgRoleMember {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	member, lastOwner, ok := app.orgMemberTarget(w, r, org)
+	if !ok {
+		return
+	}
+	if lastOwner && role != models.OrgRoleOwner {
+		app.session.Put(r, "flash", "An organisation needs at least one owner. Make someone else an owner first.")
+		http.Redirect(w, r, orgURL(org), http.StatusSeeOther)
+		return
+	}
+
+	err = app.orgs.SetRole(org.ID, member.UserID, role)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("%s is now a %s of %s.", member.UserName, role, org.Name))
+	http.Redirect(w, r, orgURL(org), http.StatusSeeOther)
+}
+
+// removeMember takes someone out of an organisation. Owners can remove
+// anyone, and members can remove themselves to leave.
+func (app *application) removeMember(w http.ResponseWriter, r *http.Request) {
+	org, ok := app.orgFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	member, lastOwner, ok := app.orgMemberTarget(w, r, org)
+	if !ok {
+		return
+	}
+	leaving := member.UserID == app.authenticatedUser(r).ID
+	if !leaving && org.Role != models.OrgRoleOwner {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+	if lastOwner {
+		app.session.Put(r, "flash", "An organisation needs at least one owner. Make someone else an owner first.")
+		http.Redirect(w, r, orgURL(org), http.StatusSeeOther)
+		return
+	}
+
+	err := app.orgs.RemoveMember(org.ID, member.UserID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if leaving {
+		app.session.Put(r, "flash", fmt.Sprintf("You have left %s.", org.Name))
+		http.Redirect(w, r, "/org", http.StatusSeeOther)
+		return
+	}
+	app.session.Put(r, "flash", fmt.Sprintf("%s has been removed from %s.", member.UserName, org.Name))
+	http.Redirect(w, r, orgURL(org), http.StatusSeeOther)
+}
+
+// invitationForm shows an emailed invitation. It's open to everyone, so
+// that someone without an account yet can be told to sign up; only the
+// user the invitation was sent to can accept it.
+func (app *application) invitationForm(w http.ResponseWriter, r *http.Request) {
+	inv, err := app.orgs.Invitation(r.URL.Query().Get("token"))
+	if err != nil && err != models.ErrInvalidToken {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "invitation.page.tmpl", &templateData{
+		Form:       forms.New(url.Values{"token": {r.URL.Query().Get("token")}}),
+		Invitation: inv,
+	})
+}
+
+func (app *application) acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+
+	inv, err := app.orgs.Invitation(token)
+	if err == models.ErrInvalidToken {
+		app.session.Put(r, "flash", "This invitation is invalid or has expired. Ask for a new one.")
+		http.Redirect(w, r, "/org", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The invitation was sent to an email address, so it can only be
+	// accepted by the account which has shown it owns that address.
+	user := app.authenticatedUser(r)
+	if !strings.EqualFold(user.Email, inv.Email) || !user.Verified {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	orgID, err := app.orgs.Accept(token, user.ID)
+	if err == models.ErrInvalidToken {
+		app.session.Put(r, "flash", "This invitation is invalid or has expired. Ask for a new one.")
+		http.Redirect(w, r, "/org", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	org, err := app.orgs.Get(orgID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("Welcome to %s!", org.Name))
+	http.Redirect(w, r, orgURL(org), http.StatusSeeOther)
+}
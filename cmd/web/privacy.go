@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// deletionGrace is how long an account is kept after its user asks for it
+// to be deleted, in case they change their mind.
+const deletionGrace = 14 * 24 * time.Hour
+
+// What to do with a user's snippets when their account is deleted.
+const (
+	snippetsAnonymise = "anonymise"
+	snippetsDelete    = "delete"
+)
+
+// personalDataFormat identifies the personal data exports made by
+// /user/data.
+const personalDataFormat = "snippetbox.personal-data"
+
+// personalDataExport is everything held about a user, as they download
+// it.
+type personalDataExport struct {
+	Format   string              `json:"format"`
+	Version  int                 `json:"version"`
+	Exported time.Time           `json:"exported"`
+	Data     models.PersonalData `json:"data"`
+}
+
+func (app *application) deleteAccountForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "deleteaccount.page.tmpl", &templateData{Form: forms.New(nil)})
+}
+
+// deleteAccount schedules the user's account to be deleted once the grace
+// period is over, and logs them out everywhere. Logging in again before
+// then lets them cancel it.
+func (app *application) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "snippets")
+	form.PermittedValues("snippets", snippetsAnonymise, snippetsDelete)
+	if form.Valid() {
+		err = app.users.CheckPassword(user.ID, form.Get("password"))
+		if err == models.ErrInvalidCredentials {
+			form.Errors.Add("password", "Password is incorrect")
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if form.Valid() {
+		orgs, err := app.soleOwnerOf(user.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if len(orgs) > 0 {
+			names := make([]string, len(orgs))
+			for i, org := range orgs {
+				names[i] = org.Name
+			}
+			form.Errors.Add("orgs", fmt.Sprintf("You're the only owner of %s. Make another member an owner first.", strings.Join(names, ", ")))
+		}
+	}
+	if !form.Valid() {
+		app.render(w, r, "deleteaccount.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	after := time.Now().UTC().Add(deletionGrace).Truncate(time.Second)
+	err = app.users.ScheduleDeletion(user.ID, after, form.Get("snippets") == snippetsAnonymise)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditDeleteRequest, UserID: user.ID, Details: form.Get("snippets")})
+
+	err = app.endUserSessions(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Remove(r, "sessionID")
+	clearRememberCookie(w)
+
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "Your Snippetbox account will be deleted",
+		Body: fmt.Sprintf(`Hi %s,
+
+As you asked, your Snippetbox account will be deleted on %s, along with
+your personal data. If you didn't ask for this, or you change your mind,
+log in before then and cancel it at:
+
+%s
+`, user.Name, humanDate(after), absoluteURL(r, "/user/delete")),
+	})
+
+	app.session.Put(r, "flash", fmt.Sprintf("Your account will be deleted on %s. Log in before then if you change your mind.", humanDate(after)))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) cancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	err := app.users.CancelDeletion(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditDeleteCancel, UserID: user.ID})
+
+	app.session.Put(r, "flash", "Your account will no longer be deleted.")
+	http.Redirect(w, r, "/user/account", http.StatusSeeOther)
+}
+
+// exportPersonalData sends the user everything held about them as JSON:
+// what the database holds, their sessions, and the audit log entries
+// about them. The IP address and browser of entries for things other
+// people did to their account are someone else's, so they're left out.
+func (app *application) exportPersonalData(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	data, err := app.users.PersonalData(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	sessions, err := app.sessions.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	data["sessions"] = []map[string]interface{}{}
+	for _, s := range sessions {
+		data["sessions"] = append(data["sessions"], map[string]interface{}{
+			"created":    s.Created,
+			"last_seen":  s.LastSeen,
+			"expires":    s.Expires,
+			"ip":         s.IP,
+			"user_agent": s.UserAgent,
+		})
+	}
+
+	data["audit_log"] = []map[string]interface{}{}
+	err = app.auditLog.Each(models.AuditFilter{UserID: user.ID}, func(e *models.AuditEvent) error {
+		record := map[string]interface{}{
+			"id":         e.ID,
+			"created":    e.Created,
+			"action":     e.Action,
+			"actor_id":   e.ActorID,
+			"user_id":    e.UserID,
+			"snippet_id": e.SnippetID,
+			"details":    e.Details,
+			"email":      e.Email,
+		}
+		if e.ActorID == user.ID {
+			record["ip"] = e.IP
+			record["user_agent"] = e.UserAgent
+		}
+		data["audit_log"] = append(data["audit_log"], record)
+		return nil
+	})
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	export := &personalDataExport{Format: personalDataFormat, Version: 1, Exported: time.Now().UTC(), Data: data}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snippetbox-personal-data-%s.json"`, export.Exported.Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "no-store")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(export); err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// The eraseAccounts method deletes the accounts whose grace period is
+// over at the given interval. It runs until the application exits.
+func (app *application) eraseAccounts(interval time.Duration) {
+	for range time.Tick(interval) {
+		users, err := app.users.DueForDeletion()
+		if err != nil {
+			app.errorLog.Print(err)
+			continue
+		}
+		for _, u := range users {
+			if err := app.eraseAccount(u); err != nil {
+				app.errorLog.Printf("deleting user %d: %s", u.ID, err)
+			}
+		}
+	}
+}
+
+// The eraseAccount helper deletes a user's account at their request. The
+// personal data in the audit log is redacted first, so that if anything
+// goes wrong the account is still there to try again with. An account
+// which is the only owner of an organisation is kept until it isn't, so
+// that the organisation isn't left without one.
+func (app *application) eraseAccount(u *models.User) error {
+	orgs, err := app.soleOwnerOf(u.ID)
+	if err != nil {
+		return err
+	}
+	if len(orgs) > 0 {
+		return fmt.Errorf("they're the only owner of organisation %d", orgs[0].ID)
+	}
+
+	// Webhooks go with the account, so the events for the snippets being
+	// deleted are queued and sent while they're still there. Each gets
+	// one attempt.
+	var deleted []*models.Snippet
+	err = app.snippets.EachByUser(u.ID, func(s *models.Snippet) error {
+		if !u.AnonymiseSnippets || s.Private {
+			deleted = append(deleted, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = app.endUserSessions(u.ID)
+	if err != nil {
+		return err
+	}
+	err = app.loginThrottle.Reset(u.Email)
+	if err != nil {
+		return err
+	}
+	redacted, err := app.auditLog.Redact(u.ID, u.Email)
+	if err != nil {
+		return err
+	}
+	for _, s := range deleted {
+		app.snippetEvent(models.EventSnippetDeleted, s)
+	}
+	if len(deleted) > 0 {
+		if err = app.webhookQueue.Deliver(); err != nil {
+			app.errorLog.Print(err)
+		}
+	}
+	err = app.users.Erase(u.ID, u.AnonymiseSnippets)
+	if err != nil {
+		return err
+	}
+
+	// The deletion itself is recorded without any personal data.
+	err = app.auditLog.Insert(&models.AuditEvent{Action: models.AuditUserDelete, ActorID: u.ID, UserID: u.ID, Details: "at their request"})
+	if err != nil {
+		app.errorLog.Printf("audit %s: %s", models.AuditUserDelete, err)
+	}
+
+	app.infoLog.Printf("deleted user %d at their request, redacting %d audit log entries", u.ID, redacted)
+	return nil
+}
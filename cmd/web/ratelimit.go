@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// A rateLimiter allows each key at most limit events in any sliding window
+// of the given length. It keeps its state in memory, so limits apply per
+// process and are reset by a restart. Keys with no events left in the
+// window are dropped from time to time so the map can't grow without
+// limit.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	hits   map[string][]time.Time
+	pruned time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		hits:   map[string][]time.Time{},
+	}
+}
+
+// Allow records an event for the key and reports whether it is within the
+// limit. Events which are refused don't count towards the limit.
+func (rl *rateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+
+	if now.Sub(rl.pruned) > rl.window {
+		for k, hits := range rl.hits {
+			if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= rl.window {
+				delete(rl.hits, k)
+			}
+		}
+		rl.pruned = now
+	}
+
+	// Forget the events which have dropped out of the window.
+	hits := rl.hits[key]
+	i := 0
+	for i < len(hits) && now.Sub(hits[i]) >= rl.window {
+		i++
+	}
+	hits = hits[i:]
+
+	if len(hits) >= rl.limit {
+		rl.hits[key] = hits
+		return false
+	}
+
+	rl.hits[key] = append(hits, now)
+	return true
+}
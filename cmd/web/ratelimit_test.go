@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(2, time.Hour)
+	rl.now = func() time.Time { return now }
+
+	if !rl.Allow("a") || !rl.Allow("a") {
+		t.Fatal("want the first two events allowed")
+	}
+	if rl.Allow("a") {
+		t.Error("want the third event within the window refused")
+	}
+	if !rl.Allow("b") {
+		t.Error("want other keys to have their own limit")
+	}
+
+	now = now.Add(time.Hour)
+	if !rl.Allow("a") {
+		t.Error("want events allowed again once the window has passed")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	rl := newRateLimiter(2, time.Hour)
+	rl.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b", "c"} {
+		rl.Allow(key)
+	}
+	now = now.Add(2 * time.Hour)
+	rl.Allow("d")
+
+	if len(rl.hits) != 1 {
+		t.Errorf("want only the new key kept; got %d keys", len(rl.hits))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// Ticking "remember me" when logging in gives the browser a long-lived
+// token in its own cookie. When the user's session has ended, the token is
+// used to start a new one, and is replaced with a new token as it's used.
+// If a token which has already been replaced turns up again, someone has
+// copied it: all of the user's sessions and tokens are ended and they're
+// told about it.
+
+const (
+	rememberCookie = "remember"
+	rememberFor    = 30 * 24 * time.Hour
+)
+
+func setRememberCookie(w http.ResponseWriter, token string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(maxAge.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearRememberCookie(w http.ResponseWriter) {
+	setRememberCookie(w, "", -time.Second)
+}
+
+// The rememberUser helper gives the browser a remember token for the user,
+// who has just logged in.
+func (app *application) rememberUser(w http.ResponseWriter, r *http.Request, userID int) error {
+	series, token, err := app.remember.New(userID, rememberFor)
+	if err != nil {
+		return err
+	}
+
+	err = app.remember.SetSession(series, hashSessionID(app.session.GetString(r, "sessionID")))
+	if err != nil {
+		return err
+	}
+
+	setRememberCookie(w, token, rememberFor)
+	return nil
+}
+
+// The restoreSession helper starts a new session from the request's remember
+// token, if it has a valid one.
+func (app *application) restoreSession(w http.ResponseWriter, r *http.Request) error {
+	cookie, err := r.Cookie(rememberCookie)
+	if err != nil {
+		return nil
+	}
+
+	userID, series, next, err := app.remember.Rotate(cookie.Value)
+	if err == models.ErrInvalidToken {
+		clearRememberCookie(w)
+		return nil
+	} else if err == models.ErrTokenReused {
+		clearRememberCookie(w)
+		app.audit(r, &models.AuditEvent{Action: models.AuditLoginFailed, UserID: userID, Details: "reused remember me token"})
+		return app.rememberTokenStolen(r, userID)
+	} else if err != nil {
+		return err
+	}
+
+	err = app.startSession(r, userID)
+	if err != nil {
+		return err
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditLogin, ActorID: userID, UserID: userID, Details: "remember me"})
+
+	err = app.remember.SetSession(series, hashSessionID(app.session.GetString(r, "sessionID")))
+	if err != nil {
+		return err
+	}
+
+	if next != "" {
+		setRememberCookie(w, next, rememberFor)
+	}
+	return nil
+}
+
+// The rememberTokenStolen helper deals with a replaced remember token being
+// used again. Either the attacker or the user has the current token, and
+// there's no telling which, so everything is ended and the user must log
+// in again.
+func (app *application) rememberTokenStolen(r *http.Request, userID int) error {
+	app.errorLog.Printf("reused remember token for user %d from %s", userID, remoteIP(r))
+
+	err := app.sessions.DeleteAllForUser(userID, "")
+	if err != nil {
+		return err
+	}
+	err = app.remember.DeleteAllForUser(userID, "")
+	if err != nil {
+		return err
+	}
+
+	user, err := app.users.Get(userID)
+	if err == models.ErrNoRecord {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	app.sendMail(&mailer.Message{
+		To:      user.Email,
+		Subject: "You've been logged out of Snippetbox",
+		Body: fmt.Sprintf(`Hi %s,
+
+Someone used an out-of-date "remember me" token for your Snippetbox account,
+which can mean it was copied from your browser. To be safe, you've been
+logged out everywhere.
+
+If you don't recognise this, change your password after logging in again:
+
+%s
+`, user.Name, absoluteURL(r, "/user/account")),
+	})
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/ardianeffendi/snippetbox/pkg/models"
 	"github.com/bmizerany/pat"
 	"github.com/justinas/alice"
 )
@@ -20,14 +21,97 @@ func (app *application) routes() http.Handler {
 
 	mux := pat.New()
 	mux.Get("/", dynamicMiddleware.ThenFunc(app.home))
-	mux.Get("/snippet/create", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.createSnippetForm))
-	mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.createSnippet))
+	mux.Get("/feed.:format", http.HandlerFunc(app.latestFeed))
+	mux.Get("/snippet/create", dynamicMiddleware.Append(app.requireAuthenticatedUser, app.requireVerifiedUser).ThenFunc(app.createSnippetForm))
+	mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthenticatedUser, app.requireVerifiedUser).ThenFunc(app.createSnippet))
 	mux.Get("/snippet/:id", dynamicMiddleware.ThenFunc(app.showSnippet))
+	mux.Post("/snippet/:id/fork", dynamicMiddleware.Append(app.requireAuthenticatedUser, app.requireVerifiedUser).ThenFunc(app.forkSnippet))
+	mux.Get("/snippet/:id/forks", dynamicMiddleware.ThenFunc(app.showForks))
+	mux.Get("/snippet/:id/stats", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.snippetStats))
+	mux.Post("/snippet/:id/star", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.starSnippet))
+	mux.Post("/snippet/:id/comments", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.createComment))
+	mux.Get("/snippet/:id/edit", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.editSnippetForm))
+	mux.Post("/snippet/:id/edit", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.editSnippet))
+	mux.Post("/snippet/:id/shares", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.shareSnippet))
+	mux.Post("/snippet/:id/shares/:user/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.unshareSnippet))
+	mux.Post("/snippet/:id/links", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.createShareLink))
+	mux.Post("/snippet/:id/links/:link/revoke", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.revokeShareLink))
+	mux.Post("/snippet/:id/report", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.reportSnippet))
+	mux.Post("/comment/:id/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.deleteComment))
+	mux.Get("/s/:token", dynamicMiddleware.ThenFunc(app.followShareLink))
+
+	mux.Get("/org", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.listOrgs))
+	mux.Post("/org", dynamicMiddleware.Append(app.requireAuthenticatedUser, app.requireVerifiedUser).ThenFunc(app.createOrg))
+	mux.Get("/org/invitation", dynamicMiddleware.ThenFunc(app.invitationForm))
+	mux.Post("/org/invitation", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.acceptInvitation))
+	mux.Get("/org/:slug", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.showOrg))
+	mux.Post("/org/:slug/invite", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.inviteMember))
+	mux.Post("/org/:slug/invitations/:id/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.withdrawInvitation))
+	mux.Post("/org/:slug/members/:id/role", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.setMemberRole))
+	mux.Post("/org/:slug/members/:id/remove", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.removeMember))
 
 	mux.Get("/user/signup", dynamicMiddleware.ThenFunc(app.signupUserForm))
 	mux.Post("/user/signup", dynamicMiddleware.ThenFunc(app.signupUser))
 	mux.Get("/user/login", dynamicMiddleware.ThenFunc(app.loginUserForm))
 	mux.Post("/user/login", dynamicMiddleware.ThenFunc(app.loginUser))
+	mux.Get("/user/login/2fa", dynamicMiddleware.ThenFunc(app.loginTwoFactorForm))
+	mux.Post("/user/login/2fa", dynamicMiddleware.ThenFunc(app.loginTwoFactor))
+	mux.Get("/user/login/sso", dynamicMiddleware.ThenFunc(app.ssoLogin))
+	mux.Get("/user/login/sso/callback", dynamicMiddleware.ThenFunc(app.ssoCallback))
+	mux.Post("/user/login/webauthn/begin", dynamicMiddleware.ThenFunc(app.beginWebAuthnLogin))
+	mux.Post("/user/login/webauthn/finish", dynamicMiddleware.ThenFunc(app.finishWebAuthnLogin))
+	mux.Get("/user/webauthn", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.webauthnCredentials))
+	mux.Post("/user/webauthn/register/begin", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.beginWebAuthnRegistration))
+	mux.Post("/user/webauthn/register/finish", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.finishWebAuthnRegistration))
+	mux.Post("/user/webauthn/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.deleteWebAuthnCredential))
+	mux.Get("/user/account", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.accountForm))
+	mux.Post("/user/account/name", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.updateName))
+	mux.Post("/user/account/email", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.updateEmail))
+	mux.Post("/user/account/password", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.changePassword))
+	mux.Get("/user/sessions", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.listSessions))
+	mux.Post("/user/sessions/revoke", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.revokeSession))
+	mux.Post("/user/sessions/revoke-all", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.revokeAllSessions))
+	mux.Get("/user/webhooks", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.listWebhooks))
+	mux.Post("/user/webhooks", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.createWebhook))
+	mux.Post("/user/webhooks/:id/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.deleteWebhook))
+	mux.Get("/user/2fa", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.twoFactorForm))
+	mux.Post("/user/2fa/enable", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.enableTwoFactor))
+	mux.Post("/user/2fa/disable", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.disableTwoFactor))
+	mux.Get("/user/:id/snippets", dynamicMiddleware.ThenFunc(app.userSnippets))
+	mux.Get("/user/:id/feed.:format", http.HandlerFunc(app.userFeed))
+	mux.Get("/user/stars", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.userStars))
+	mux.Get("/user/shared", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.sharedSnippets))
+	mux.Get("/user/data", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.exportPersonalData))
+	mux.Get("/user/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.deleteAccountForm))
+	mux.Post("/user/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.deleteAccount))
+	mux.Post("/user/delete/cancel", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.cancelAccountDeletion))
+	mux.Get("/user/export", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.exportSnippets))
+	mux.Get("/user/import", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.importForm))
+	mux.Post("/user/import", alice.New(maxBytes(maxImportSize+1<<20)).Extend(dynamicMiddleware).Append(app.requireAuthenticatedUser, app.requireVerifiedUser).ThenFunc(app.importSnippets))
+	mux.Get("/user/password/forgot", dynamicMiddleware.ThenFunc(app.forgotPasswordForm))
+	mux.Post("/user/password/forgot", dynamicMiddleware.ThenFunc(app.forgotPassword))
+	mux.Get("/user/password/reset", dynamicMiddleware.ThenFunc(app.resetPasswordForm))
+	mux.Post("/user/password/reset", dynamicMiddleware.ThenFunc(app.resetPassword))
+	mux.Get("/user/verify", dynamicMiddleware.ThenFunc(app.verifyUserForm))
+	mux.Post("/user/verify", dynamicMiddleware.ThenFunc(app.verifyUser))
+	mux.Get("/user/verification", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.verificationStatus))
+	mux.Post("/user/verification/resend", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.resendVerification))
+	admin := dynamicMiddleware.Append(app.requireRole(models.RoleAdmin))
+	moderator := dynamicMiddleware.Append(app.requireRole(models.RoleModerator))
+	mux.Get("/admin", admin.ThenFunc(app.adminDashboard))
+	mux.Get("/admin/users", admin.ThenFunc(app.adminUsers))
+	mux.Post("/admin/users/:id/role", admin.ThenFunc(app.adminSetUserRole))
+	mux.Post("/admin/users/:id/disable", admin.ThenFunc(app.adminDisableUser))
+	mux.Post("/admin/users/:id/delete", admin.ThenFunc(app.adminDeleteUser))
+	mux.Get("/admin/audit", admin.ThenFunc(app.adminAudit))
+	mux.Get("/admin/audit/export", admin.ThenFunc(app.adminExportAudit))
+	mux.Post("/admin/audit/verify", admin.ThenFunc(app.adminVerifyAudit))
+	mux.Get("/moderation", moderator.ThenFunc(app.moderationQueue))
+	mux.Post("/moderation/reports/:id", moderator.ThenFunc(app.moderateReport))
+	mux.Get("/admin/snippets", moderator.ThenFunc(app.adminSnippets))
+	mux.Post("/admin/snippets/:id/hide", moderator.ThenFunc(app.adminHideSnippet))
+	mux.Post("/admin/snippets/:id/delete", moderator.ThenFunc(app.adminDeleteSnippet))
+
 	mux.Post("/user/logout", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.logoutUser))
 
 	// Create a file server which serves files out of the "./ui/static" directory
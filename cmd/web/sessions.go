@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// Logged in sessions are kept on the server, so they can be listed and
+// revoked. The encrypted session cookie only holds an opaque session ID,
+// and the store only holds the SHA-256 hash of that ID, so the contents of
+// the store can't be used to take over a session.
+
+// A sessionStore stores logged in sessions. Both the in-memory store below
+// and mysql.SessionModel satisfy it; use the database store when running
+// more than one instance of the application.
+type sessionStore interface {
+	Insert(s *models.Session) error
+	Get(id string) (*models.Session, error)
+	Touch(id string, at time.Time, ip string) error
+	ForUser(userID int) ([]*models.Session, error)
+	Delete(userID int, id string) error
+	DeleteAllForUser(userID int, except string) error
+	DeleteExpired() error
+}
+
+// How often a session's last seen time and IP address are updated.
+const touchInterval = time.Minute
+
+// A memorySessionStore is a sessionStore which keeps sessions in memory, so
+// they are lost when the application restarts.
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]models.Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: map[string]models.Session{}}
+}
+
+func (m *memorySessionStore) Insert(s *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = *s
+	return nil
+}
+
+func (m *memorySessionStore) Get(id string) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || !time.Now().Before(s.Expires) {
+		return nil, models.ErrNoRecord
+	}
+	return &s, nil
+}
+
+func (m *memorySessionStore) Touch(id string, at time.Time, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		s.LastSeen = at
+		s.IP = ip
+		m.sessions[id] = s
+	}
+	return nil
+}
+
+func (m *memorySessionStore) ForUser(userID int) ([]*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	sessions := []*models.Session{}
+	for _, s := range m.sessions {
+		if s.UserID == userID && now.Before(s.Expires) {
+			s := s
+			sessions = append(sessions, &s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+func (m *memorySessionStore) Delete(userID int, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok && s.UserID == userID {
+		delete(m.sessions, id)
+	}
+	return nil
+}
+
+func (m *memorySessionStore) DeleteAllForUser(userID int, except string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.UserID == userID && id != except {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *memorySessionStore) DeleteExpired() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, s := range m.sessions {
+		if !now.Before(s.Expires) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// The hashSessionID helper returns the hash a session is stored under.
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// The remoteIP helper returns the IP address a request came from.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// The startSession helper creates a session for the user and stores its ID
+// in the session cookie.
+func (app *application) startSession(r *http.Request, userID int) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	ua := r.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+
+	now := time.Now()
+	err := app.sessions.Insert(&models.Session{
+		ID:        hashSessionID(id),
+		UserID:    userID,
+		Created:   now,
+		LastSeen:  now,
+		Expires:   now.Add(app.session.Lifetime),
+		IP:        remoteIP(r),
+		UserAgent: ua,
+	})
+	if err != nil {
+		return err
+	}
+
+	app.session.Put(r, "sessionID", id)
+	return nil
+}
+
+// The lookupSession helper returns the server-side session whose ID is in the
+// session cookie, or nil if there isn't one. If the session has expired or
+// been revoked its ID is removed from the cookie.
+func (app *application) lookupSession(r *http.Request) (*models.Session, error) {
+	if !app.session.Exists(r, "sessionID") {
+		return nil, nil
+	}
+
+	sess, err := app.sessions.Get(hashSessionID(app.session.GetString(r, "sessionID")))
+	if err == models.ErrNoRecord {
+		app.session.Remove(r, "sessionID")
+		return nil, nil
+	}
+	return sess, err
+}
+
+// The currentSession helper returns the hash of the current request's
+// session ID, or "" if it isn't logged in.
+func (app *application) currentSession(r *http.Request) string {
+	id, ok := r.Context().Value(contextKeySession).(string)
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// The cleanSessions method deletes expired sessions from the store at the
+// given interval. It runs until the application exits.
+func (app *application) cleanSessions(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := app.sessions.DeleteExpired(); err != nil {
+			app.errorLog.Print(err)
+		}
+	}
+}
+
+// The describeUserAgent helper turns a User-Agent header into a short
+// description of the browser and operating system, like "Firefox on
+// Windows". It only needs to be good enough for people to recognise their
+// own devices.
+func describeUserAgent(ua string) string {
+	var browser, os string
+
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"), strings.Contains(ua, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	}
+
+	switch {
+	case strings.Contains(ua, "Android"):
+		os = "Android"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		os = "iOS"
+	case strings.Contains(ua, "Windows"):
+		os = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		os = "macOS"
+	case strings.Contains(ua, "CrOS"):
+		os = "ChromeOS"
+	case strings.Contains(ua, "Linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
+
+func (app *application) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.sessions.ForUser(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "sessions.page.tmpl", &templateData{
+		CurrentSession: app.currentSession(r),
+		Sessions:       sessions,
+	})
+}
+
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+
+	err = app.sessions.Delete(user.ID, r.PostForm.Get("id"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Make sure a "remember me" token can't bring the session back. Only
+	// the user's own tokens are deleted, so another user's session ID
+	// deletes nothing.
+	err = app.remember.DeleteForSession(user.ID, r.PostForm.Get("id"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if r.PostForm.Get("id") == app.currentSession(r) {
+		app.session.Remove(r, "sessionID")
+		clearRememberCookie(w)
+		app.session.Put(r, "flash", "You have been logged out successfully!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.session.Put(r, "flash", "The session has been signed out.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+func (app *application) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	err := app.sessions.DeleteAllForUser(app.authenticatedUser(r).ID, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.remember.DeleteAllForUser(app.authenticatedUser(r).ID, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Remove(r, "sessionID")
+	clearRememberCookie(w)
+	app.session.Put(r, "flash", "You have been signed out everywhere.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	store := newMemorySessionStore()
+	now := time.Now()
+
+	for _, s := range []*models.Session{
+		{ID: "a", UserID: 1, LastSeen: now.Add(-time.Hour), Expires: now.Add(time.Hour)},
+		{ID: "b", UserID: 1, LastSeen: now, Expires: now.Add(time.Hour)},
+		{ID: "c", UserID: 1, LastSeen: now, Expires: now.Add(-time.Second)},
+		{ID: "d", UserID: 2, LastSeen: now, Expires: now.Add(time.Hour)},
+	} {
+		if err := store.Insert(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := store.Get("c"); err != models.ErrNoRecord {
+		t.Errorf("want expired session to be %v; got %v", models.ErrNoRecord, err)
+	}
+
+	sessions, err := store.ForUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != "b" || sessions[1].ID != "a" {
+		t.Errorf("want sessions b, a; got %v", sessions)
+	}
+
+	// Users can't sign out each other's sessions.
+	store.Delete(2, "a")
+	if _, err := store.Get("a"); err != nil {
+		t.Errorf("want session a kept; got %v", err)
+	}
+
+	store.DeleteAllForUser(1, "b")
+	if _, err := store.Get("a"); err != models.ErrNoRecord {
+		t.Errorf("want session a deleted; got %v", err)
+	}
+	if _, err := store.Get("b"); err != nil {
+		t.Errorf("want session b kept; got %v", err)
+	}
+	if _, err := store.Get("d"); err != nil {
+		t.Errorf("want other users' sessions kept; got %v", err)
+	}
+}
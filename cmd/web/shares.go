@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/mailer"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// The ownSnippet helper returns the snippet with the ID from the URL, if
+// the current user wrote it. Only authors can choose who they share their
+// snippets with.
+func (app *application) ownSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+
+	s, err := app.snippets.Get(id, app.viewer(r))
+	if err == models.ErrNoRecord || err == models.ErrTakenDown {
+		app.notFound(w)
+		return nil, false
+	} else if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	if s.UserID == 0 || s.UserID != app.authenticatedUser(r).ID {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return s, true
+}
+
+func (app *application) shareSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "access")
+	form.MatchesPattern("email", forms.EmailRX)
+	form.PermittedValues("access", models.AccessLevels...)
+
+	// The snippet is shared with an existing account, found by its email
+	// address.
+	user := app.authenticatedUser(r)
+	var target *models.User
+	if form.Valid() {
+		target, err = app.users.GetByEmail(form.Get("email"))
+		if err == models.ErrNoRecord {
+			form.Errors.Add("email", "There's no user with this email address")
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		} else if target.ID == user.ID {
+			form.Errors.Add("email", "You can't share a snippet with yourself")
+		}
+	}
+	if !form.Valid() {
+		app.renderSnippet(w, r, s, form)
+		return
+	}
+
+	err = app.shares.Set(s.ID, target.ID, form.Get("access"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditSnippetShare, UserID: target.ID, SnippetID: s.ID, Details: form.Get("access")})
+
+	verb := "read"
+	if form.Get("access") == models.AccessEdit {
+		verb = "read and edit"
+	}
+	app.sendMail(&mailer.Message{
+		To:      target.Email,
+		Subject: fmt.Sprintf("%s shared a snippet with you", user.Name),
+		Body: fmt.Sprintf(`Hi %s,
+
+%s has shared the snippet "%s" with you on Snippetbox. You can %s it
+while you're logged in:
+
+%s
+
+You can find all the snippets shared with you at %s.
+`, target.Name, user.Name, s.Title, verb, absoluteURL(r, fmt.Sprintf("/snippet/%d", s.ID)), absoluteURL(r, "/user/shared")),
+	})
+
+	app.session.Put(r, "flash", fmt.Sprintf("%s can now %s this snippet.", target.Name, verb))
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", s.ID), http.StatusSeeOther)
+}
+
+func (app *application) unshareSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get(":user"))
+	if err != nil || userID < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.shares.Delete(s.ID, userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditSnippetUnshare, UserID: userID, SnippetID: s.ID})
+
+	app.session.Put(r, "flash", "The snippet is no longer shared with them.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", s.ID), http.StatusSeeOther)
+}
+
+func (app *application) sharedSnippets(w http.ResponseWriter, r *http.Request) {
+	page, ok := pageNumber(r)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	s, err := app.snippets.SharedWith((page-1)*pageSize, pageSize+1, app.viewer(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	td := &templateData{
+		Snippets: s,
+		PrevPage: page - 1,
+	}
+	if len(s) > pageSize {
+		td.Snippets = s[:pageSize]
+		td.NextPage = page + 1
+	}
+
+	app.render(w, r, "shared.page.tmpl", td)
+}
@@ -3,22 +3,59 @@ package main
 import (
 	"html/template"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/markdown"
 	"github.com/ardianeffendi/snippetbox/pkg/models"
+	"github.com/ardianeffendi/snippetbox/pkg/webauthn"
 )
 
 // Define a templateData type to act as the holding structure for
 // any dynamic data that we want to pass to our HTML templates.
 type templateData struct {
+	AccessLevels      []string
+	AuditActions      []string
+	AuditEvents       []*models.AuditEvent
 	AuthenticatedUser *models.User
+	Comments          []*threadedComment
+	Credentials       []*models.WebAuthnCredential
 	CSRFToken         string
+	CurrentSession    string
 	CurrentYear       int
+	DecidedReports    []*models.Report
+	Deliveries        []*models.WebhookDelivery
 	Flash             string
 	Form              *forms.Form
+	Import            *importResult
+	Invitation        *models.Invitation
+	Invitations       []*models.Invitation
+	Lines             []*snippetLine
+	Members           []*models.Membership
+	NextPage          int
+	Organisation      *models.Organisation
+	Organisations     []*models.Organisation
+	OrgRoles          []string
+	PrevPage          int
+	Query             string
+	Reports           []*models.Report
+	ReportReasons     []string
+	Roles             []string
 	Snippet           *models.Snippet
 	Snippets          []*models.Snippet
+	SystemStats       *models.SystemStats
+	Sessions          []*models.Session
+	ShareLinks        []*shareLink
+	Shares            []*models.Share
+	Sort              string
+	SSO               bool
+	Starred           bool
+	Stats             *snippetStats
+	TwoFactor         *twoFactorSetup
+	User              *models.User
+	Users             []*models.User
+	Webhooks          []*models.Webhook
 }
 
 // Create a humanDate function which returns a nicely formatted string
@@ -32,11 +69,22 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// The shortDate function formats a time.Time as just its (UTC) day and month.
+func shortDate(t time.Time) string {
+	return t.UTC().Format("02 Jan")
+}
+
 // Initialise a template.FuncMap object and store it in a global variable. This is
 // essentially a string-keyed map which acts as a lookup between the names of our
 // custom template function and the functions themselves.
 var functions = template.FuncMap{
+	"base64url": webauthn.Encoding.EncodeToString,
+	"device":    describeUserAgent,
+	"eqFold":    strings.EqualFold,
 	"humanDate": humanDate,
+	"markdown":  markdown.Render,
+	"reason":    reasonLabel,
+	"shortDate": shortDate,
 }
 
 func newTemplateCache(dir string) (map[string]*template.Template, error) {
@@ -46,3 +46,18 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestNewTemplateCache(t *testing.T) {
+	// Parse every page in the ui/html directory so that a typo in any
+	// template is caught by the test suite rather than at request time.
+	cache, err := newTemplateCache("../../ui/html/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"home.page.tmpl", "show.page.tmpl", "forks.page.tmpl", "stars.page.tmpl", "stats.page.tmpl", "user.page.tmpl", "forgot.page.tmpl", "reset.page.tmpl", "verify.page.tmpl", "verification.page.tmpl", "twofactor.page.tmpl", "login2fa.page.tmpl", "webauthn.page.tmpl", "account.page.tmpl", "sessions.page.tmpl", "admin.page.tmpl", "adminusers.page.tmpl", "adminsnippets.page.tmpl", "moderation.page.tmpl", "audit.page.tmpl", "webhooks.page.tmpl", "orgs.page.tmpl", "org.page.tmpl", "invitation.page.tmpl", "edit.page.tmpl", "shared.page.tmpl", "link.page.tmpl", "import.page.tmpl", "deleteaccount.page.tmpl"} {
+		if _, ok := cache[name]; !ok {
+			t.Errorf("want template %q in cache", name)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+	"github.com/ardianeffendi/snippetbox/pkg/totp"
+	"github.com/skip2/go-qrcode"
+)
+
+// twoFactorTimeout is how long a user has to enter their code after their
+// password has been accepted.
+const twoFactorTimeout = 5 * time.Minute
+
+// twoFactorSetup holds what the enrolment page shows while 2FA is being set
+// up, or the recovery codes once it has been.
+type twoFactorSetup struct {
+	Secret        string
+	QRCode        template.URL
+	RecoveryCodes []string
+}
+
+// The generateRecoveryCodes helper returns ten random single-use codes of
+// the form "abcde-fghij", each holding 50 bits of randomness.
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 10)
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// The qrCodeDataURL helper renders the content as a PNG QR code and returns
+// it as a data: URL which can be used directly as an image source.
+func qrCodeDataURL(content string) (template.URL, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
+
+func (app *application) twoFactorForm(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user.TwoFactor {
+		app.render(w, r, "twofactor.page.tmpl", &templateData{Form: forms.New(nil)})
+		return
+	}
+
+	app.renderTwoFactorEnrolment(w, r, user, forms.New(nil))
+}
+
+// The renderTwoFactorEnrolment helper shows the QR code for a new TOTP
+// secret. The secret is kept in the (encrypted) session cookie until the
+// user proves they've set up their authenticator by entering a code, and
+// only then is it saved against their account.
+func (app *application) renderTwoFactorEnrolment(w http.ResponseWriter, r *http.Request, user *models.User, form *forms.Form) {
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		var err error
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "pendingTOTPSecret", secret)
+	}
+
+	qr, err := qrCodeDataURL(totp.URL("Snippetbox", user.Email, secret))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "twofactor.page.tmpl", &templateData{
+		Form:      form,
+		TwoFactor: &twoFactorSetup{Secret: secret, QRCode: qr},
+	})
+}
+
+func (app *application) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user.TwoFactor {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	counter, ok := totp.Verify(secret, form.Get("code"), time.Now(), 1)
+	if !ok {
+		form.Errors.Add("code", "This code is incorrect. Check the time on your device is right and try again.")
+	}
+	if !form.Valid() {
+		app.renderTwoFactorEnrolment(w, r, user, form)
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.EnableTwoFactor(user.ID, secret, codes)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Don't let the code which was just used to enrol be used to log in.
+	_, err = app.users.UseTOTPCounter(user.ID, counter)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Remove(r, "pendingTOTPSecret")
+
+	// The recovery codes are shown this once, and only their hashes are kept.
+	user.TwoFactor = true
+	app.render(w, r, "twofactor.page.tmpl", &templateData{
+		Form:      forms.New(nil),
+		TwoFactor: &twoFactorSetup{RecoveryCodes: codes},
+	})
+}
+
+func (app *application) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Turning 2FA off needs a current code, so that someone who has got
+	// hold of a logged in session can't quietly disable it.
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if form.Valid() {
+		ok, err := app.checkSecondFactor(user.ID, form.Get("code"))
+		if err == models.ErrNoRecord {
+			app.session.Put(r, "flash", "Two-factor authentication isn't turned on.")
+			http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		if !ok {
+			form.Errors.Add("code", "This code is incorrect")
+		}
+	}
+	if !form.Valid() {
+		app.render(w, r, "twofactor.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	err = app.users.DisableTwoFactor(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Two-factor authentication has been turned off.")
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+// The checkSecondFactor helper accepts either a TOTP code which hasn't been
+// used before or one of the user's unused recovery codes.
+func (app *application) checkSecondFactor(userID int, code string) (bool, error) {
+	secret, err := app.users.TwoFactorSecret(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if counter, ok := totp.Verify(secret, code, time.Now(), 1); ok {
+		return app.users.UseTOTPCounter(userID, counter)
+	}
+
+	return app.users.UseRecoveryCode(userID, strings.ToLower(strings.TrimSpace(code)))
+}
+
+// The pendingTwoFactorUser helper returns the ID of the user whose password
+// has been accepted but who still has to enter their code, or 0 if there
+// isn't one or they took too long.
+func (app *application) pendingTwoFactorUser(r *http.Request) int {
+	id := app.session.GetInt(r, "pendingTwoFactorUserID")
+	started := app.session.GetInt(r, "pendingTwoFactorStarted")
+	if id == 0 || time.Since(time.Unix(int64(started), 0)) > twoFactorTimeout {
+		return 0
+	}
+	return id
+}
+
+func (app *application) loginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUser(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "login2fa.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUser(r)
+	if id == 0 {
+		app.session.Put(r, "flash", "Your login timed out. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// Six digit codes can be guessed, so cap the number of attempts.
+	if !app.twoFactorLimiter.Allow(strconv.Itoa(id)) {
+		form.Errors.Add("generic", "Too many attempts. Please wait a few minutes and try again.")
+		app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	ok, err := app.checkSecondFactor(id, form.Get("code"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !ok {
+		app.audit(r, &models.AuditEvent{Action: models.AuditLoginFailed, UserID: id, Details: "two-factor code"})
+		form.Errors.Add("generic", "This code is incorrect")
+		app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// The second step is complete, so swap the pending user for a real
+	// logged in one.
+	app.session.Remove(r, "pendingTwoFactorUserID")
+	app.session.Remove(r, "pendingTwoFactorStarted")
+	remember := app.session.PopBool(r, "pendingTwoFactorRemember")
+	err = app.logIn(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditLogin, ActorID: id, UserID: id, Details: "two-factor code"})
+
+	if remember {
+		err = app.rememberUser(w, r, id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// The startTwoFactorLogin helper records that the user's password has been
+// accepted, and whether they asked to be remembered, and sends them on to
+// the second step of logging in.
+func (app *application) startTwoFactorLogin(w http.ResponseWriter, r *http.Request, id int, remember bool) {
+	app.session.Put(r, "pendingTwoFactorUserID", id)
+	app.session.Put(r, "pendingTwoFactorStarted", int(time.Now().Unix()))
+	app.session.Put(r, "pendingTwoFactorRemember", remember)
+	http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+}
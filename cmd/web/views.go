@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// viewStore is where the viewRecorder flushes its buffered views to. It is
+// satisfied by *mysql.ViewModel.
+type viewStore interface {
+	AddBatch(batch []*models.SnippetViews) error
+}
+
+type viewKey struct {
+	snippetID int
+	day       time.Time
+}
+
+// The viewRecorder counts snippet views in memory so that recording a view
+// doesn't add a database write to every request. The counts are flushed to
+// the store in a single batch every interval, or sooner once maxPending
+// distinct snippets are waiting.
+//
+// Unique visitors are identified by a keyed hash of their IP address and
+// user agent. The key is random and replaced every day (and on every
+// restart), so the stored identifiers can't be traced back to an address
+// or linked across days.
+type viewRecorder struct {
+	store      viewStore
+	errorLog   *log.Logger
+	maxPending int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	day     time.Time
+	salt    []byte
+	pending map[viewKey]*models.SnippetViews
+	seen    map[viewKey]map[string]bool
+	full    chan struct{}
+}
+
+func newViewRecorder(store viewStore, errorLog *log.Logger) *viewRecorder {
+	return &viewRecorder{
+		store:      store,
+		errorLog:   errorLog,
+		maxPending: 1000,
+		now:        time.Now,
+		pending:    map[viewKey]*models.SnippetViews{},
+		seen:       map[viewKey]map[string]bool{},
+		full:       make(chan struct{}, 1),
+	}
+}
+
+// Record counts a view of the snippet by the client making the request.
+func (v *viewRecorder) Record(snippetID int, r *http.Request) {
+	ip := remoteIP(r)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	day := v.now().UTC().Truncate(24 * time.Hour)
+	if !day.Equal(v.day) || v.salt == nil {
+		v.day = day
+		v.salt = make([]byte, 32)
+		if _, err := rand.Read(v.salt); err != nil {
+			v.errorLog.Print(err)
+			return
+		}
+	}
+
+	mac := hmac.New(sha256.New, v.salt)
+	mac.Write([]byte(ip + "\x00" + r.UserAgent()))
+	visitor := hex.EncodeToString(mac.Sum(nil))[:32]
+
+	key := viewKey{snippetID, day}
+	sv, ok := v.pending[key]
+	if !ok {
+		sv = &models.SnippetViews{SnippetID: snippetID, Day: day}
+		v.pending[key] = sv
+		v.seen[key] = map[string]bool{}
+	}
+	sv.Views++
+	if !v.seen[key][visitor] {
+		v.seen[key][visitor] = true
+		sv.Visitors = append(sv.Visitors, visitor)
+	}
+
+	// Ask the flushing goroutine to write the buffer out early if it's
+	// getting large. The channel is buffered, so this never blocks.
+	if len(v.pending) >= v.maxPending {
+		select {
+		case v.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush writes all the buffered views to the store. If the write fails the
+// views are logged as lost rather than kept, so that a database outage can't
+// make the buffer grow without bound.
+func (v *viewRecorder) Flush() {
+	v.mu.Lock()
+	batch := make([]*models.SnippetViews, 0, len(v.pending))
+	for _, sv := range v.pending {
+		batch = append(batch, sv)
+	}
+	v.pending = map[viewKey]*models.SnippetViews{}
+	v.seen = map[viewKey]map[string]bool{}
+	v.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := v.store.AddBatch(batch); err != nil {
+		v.errorLog.Printf("dropping views of %d snippets: %s", len(batch), err)
+	}
+}
+
+// Run flushes the buffered views every interval, and whenever the buffer
+// fills up. It never returns, so it should be started in its own goroutine.
+func (v *viewRecorder) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-v.full:
+		}
+		v.Flush()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+type batchStore struct {
+	batches [][]*models.SnippetViews
+}
+
+func (s *batchStore) AddBatch(batch []*models.SnippetViews) error {
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func TestViewRecorder(t *testing.T) {
+	store := &batchStore{}
+	v := newViewRecorder(store, log.New(new(bytes.Buffer), "", 0))
+	v.now = func() time.Time { return time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC) }
+
+	request := func(addr, ua string) *http.Request {
+		r, err := http.NewRequest("GET", "/snippet/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RemoteAddr = addr
+		r.Header.Set("User-Agent", ua)
+		return r
+	}
+
+	v.Record(1, request("10.0.0.1:1234", "a"))
+	v.Record(1, request("10.0.0.1:5678", "a"))
+	v.Record(1, request("10.0.0.2:1234", "a"))
+	v.Record(2, request("10.0.0.1:1234", "b"))
+
+	// Nothing should reach the store until the buffer is flushed.
+	if len(store.batches) != 0 {
+		t.Fatalf("want no batches before flushing; got %d", len(store.batches))
+	}
+
+	v.Flush()
+	if len(store.batches) != 1 {
+		t.Fatalf("want 1 batch; got %d", len(store.batches))
+	}
+
+	got := map[int]*models.SnippetViews{}
+	for _, sv := range store.batches[0] {
+		got[sv.SnippetID] = sv
+	}
+	if got[1].Views != 3 || len(got[1].Visitors) != 2 {
+		t.Errorf("snippet 1: want 3 views by 2 visitors; got %d by %d", got[1].Views, len(got[1].Visitors))
+	}
+	if got[2].Views != 1 || len(got[2].Visitors) != 1 {
+		t.Errorf("snippet 2: want 1 view by 1 visitor; got %d by %d", got[2].Views, len(got[2].Visitors))
+	}
+
+	// The visitor identifiers mustn't contain the address.
+	for _, visitor := range got[1].Visitors {
+		if bytes.Contains([]byte(visitor), []byte("10.0.0")) {
+			t.Errorf("visitor identifier %q contains the IP address", visitor)
+		}
+	}
+
+	// A second flush with nothing buffered shouldn't touch the store.
+	v.Flush()
+	if len(store.batches) != 1 {
+		t.Errorf("want 1 batch after empty flush; got %d", len(store.batches))
+	}
+}
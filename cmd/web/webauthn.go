@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+	"github.com/ardianeffendi/snippetbox/pkg/webauthn"
+)
+
+// The WebAuthn ceremonies are driven by JavaScript in main.js, which posts
+// JSON to these handlers. Binary values are base64url encoded. The challenge
+// for each ceremony is kept in the session between its begin and finish
+// requests, and is removed as soon as it has been used.
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialResponse struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// The relyingParty helper describes the site the request was made to. The
+// browser binds credentials to the origin it actually talked to, so taking
+// it from the request doesn't let anyone use a credential elsewhere.
+func relyingParty(r *http.Request) *webauthn.RelyingParty {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	return &webauthn.RelyingParty{ID: host, Origin: absoluteURL(r, "")}
+}
+
+// The writeJSON helper sends v as a JSON response.
+func (app *application) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// The newWebAuthnChallenge helper creates a challenge and remembers it in
+// the session for the finish request.
+func (app *application) newWebAuthnChallenge(r *http.Request) (string, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+	encoded := webauthn.Encoding.EncodeToString(challenge)
+	app.session.Put(r, "webauthnChallenge", encoded)
+	return encoded, nil
+}
+
+// The decodeCredentialResponse helper reads a finish request, and pops the
+// challenge it should answer from the session.
+func (app *application) decodeCredentialResponse(r *http.Request) (*credentialResponse, []byte, bool) {
+	var resp credentialResponse
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 64*1024)).Decode(&resp); err != nil {
+		return nil, nil, false
+	}
+
+	challenge, err := webauthn.Encoding.DecodeString(app.session.PopString(r, "webauthnChallenge"))
+	if err != nil || len(challenge) == 0 {
+		return nil, nil, false
+	}
+	return &resp, challenge, true
+}
+
+func (app *application) webauthnCredentials(w http.ResponseWriter, r *http.Request) {
+	creds, err := app.credentials.ForUser(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "webauthn.page.tmpl", &templateData{
+		Credentials: creds,
+		Form:        forms.New(nil),
+	})
+}
+
+func (app *application) beginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	creds, err := app.credentials.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	challenge, err := app.newWebAuthnChallenge(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Stop the same authenticator being registered twice.
+	exclude := []credentialDescriptor{}
+	for _, c := range creds {
+		exclude = append(exclude, credentialDescriptor{Type: "public-key", ID: webauthn.Encoding.EncodeToString(c.ID)})
+	}
+	params := []credentialParameter{}
+	for _, alg := range webauthn.Algorithms {
+		params = append(params, credentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	rp := relyingParty(r)
+	app.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge": challenge,
+		"rp":        map[string]string{"id": rp.ID, "name": "Snippetbox"},
+		"user": map[string]string{
+			"id":          webauthn.Encoding.EncodeToString([]byte(strconv.Itoa(user.ID))),
+			"name":        user.Email,
+			"displayName": user.Name,
+		},
+		"pubKeyCredParams":   params,
+		"excludeCredentials": exclude,
+		"timeout":            60000,
+		"attestation":        "none",
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "required",
+			"userVerification": "required",
+		},
+	})
+}
+
+func (app *application) finishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	resp, challenge, ok := app.decodeCredentialResponse(r)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	clientDataJSON, err1 := webauthn.Encoding.DecodeString(resp.Response.ClientDataJSON)
+	attestation, err2 := webauthn.Encoding.DecodeString(resp.Response.AttestationObject)
+	if err1 != nil || err2 != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	cred, err := relyingParty(r).VerifyRegistration(challenge, clientDataJSON, attestation)
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "The passkey could not be verified."})
+		return
+	}
+
+	name := strings.TrimSpace(resp.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len([]rune(name)) > 100 {
+		name = string([]rune(name)[:100])
+	}
+
+	err = app.credentials.Insert(app.authenticatedUser(r).ID, name, cred.ID, cred.PublicKey, cred.SignCount)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your passkey has been added.")
+	app.writeJSON(w, http.StatusOK, map[string]string{"redirect": "/user/webauthn"})
+}
+
+func (app *application) deleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := webauthn.Encoding.DecodeString(r.PostForm.Get("id"))
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.credentials.Delete(app.authenticatedUser(r).ID, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "The passkey has been removed.")
+	http.Redirect(w, r, "/user/webauthn", http.StatusSeeOther)
+}
+
+// beginWebAuthnLogin starts a login with a discoverable passkey: the
+// browser offers the ones it has stored for the site. No credentials are
+// listed, as listing them for an email address would reveal whether it has
+// an account.
+func (app *application) beginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	challenge, err := app.newWebAuthnChallenge(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"challenge":        challenge,
+		"rpId":             relyingParty(r).ID,
+		"timeout":          60000,
+		"userVerification": "required",
+		"allowCredentials": []credentialDescriptor{},
+	})
+}
+
+func (app *application) finishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	failed := func() {
+		app.audit(r, &models.AuditEvent{Action: models.AuditLoginFailed, Details: "passkey"})
+		app.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "That passkey wasn't recognised."})
+	}
+
+	resp, challenge, ok := app.decodeCredentialResponse(r)
+	if !ok {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := webauthn.Encoding.DecodeString(resp.ID)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	clientDataJSON, err1 := webauthn.Encoding.DecodeString(resp.Response.ClientDataJSON)
+	authData, err2 := webauthn.Encoding.DecodeString(resp.Response.AuthenticatorData)
+	signature, err3 := webauthn.Encoding.DecodeString(resp.Response.Signature)
+	if err1 != nil || err2 != nil || err3 != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	stored, err := app.credentials.Get(id)
+	if err == models.ErrNoRecord {
+		failed()
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// If the authenticator says which user it's signing in, it must be the
+	// owner of the credential.
+	if resp.Response.UserHandle != "" {
+		handle, err := webauthn.Encoding.DecodeString(resp.Response.UserHandle)
+		if err != nil || string(handle) != strconv.Itoa(stored.UserID) {
+			failed()
+			return
+		}
+	}
+
+	cred := &webauthn.Credential{ID: stored.ID, PublicKey: stored.PublicKey, SignCount: stored.SignCount}
+	signCount, err := relyingParty(r).VerifyAssertion(cred, challenge, clientDataJSON, authData, signature)
+	if err == webauthn.ErrClonedKey {
+		app.errorLog.Printf("possible cloned WebAuthn credential for user %d", stored.UserID)
+		failed()
+		return
+	} else if err != nil {
+		failed()
+		return
+	}
+
+	err = app.credentials.Used(stored.ID, signCount)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Disabled users get the same response as an unknown passkey, as they
+	// do for a wrong password.
+	user, err := app.users.Get(stored.UserID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if user.Disabled {
+		failed()
+		return
+	}
+
+	// A passkey the user has been verified with is something they have
+	// and something they know or are, so logging in with one skips the
+	// TOTP step.
+	err = app.logIn(r, stored.UserID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.audit(r, &models.AuditEvent{Action: models.AuditLogin, ActorID: stored.UserID, UserID: stored.UserID, Details: "passkey"})
+	app.writeJSON(w, http.StatusOK, map[string]string{"redirect": "/snippet/create"})
+}
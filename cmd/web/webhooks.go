@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/forms"
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// maxWebhooks is the number of webhooks each user can register.
+const maxWebhooks = 5
+
+// Expired snippets are looked for as far back as expiryLookback when the
+// application starts, so that expiries while it was down aren't missed.
+// Finished deliveries are kept in the log for deliveryRetention.
+const (
+	expiryLookback    = 24 * time.Hour
+	deliveryRetention = 30 * 24 * time.Hour
+)
+
+// webhookStore is where the webhookDispatcher queues deliveries. It is
+// satisfied by *mysql.WebhookModel.
+type webhookStore interface {
+	ForUser(userID int) ([]*models.Webhook, error)
+	Enqueue(webhookID int, event string, snippetID int, payload []byte, once bool) error
+	Due(now time.Time, limit int) ([]*models.WebhookDelivery, error)
+	Update(d *models.WebhookDelivery) error
+	Expired(since, until time.Time) ([]*models.Snippet, error)
+	Prune(before time.Time) error
+}
+
+// The webhookDispatcher sends snippet events to users' webhooks. Events
+// are queued in the store, so they survive a restart, and a delivery
+// which fails is retried with exponential backoff: after backoff, then
+// twice that, and so on, until maxAttempts have been made.
+type webhookDispatcher struct {
+	store       webhookStore
+	client      *http.Client
+	errorLog    *log.Logger
+	now         func() time.Time
+	backoff     time.Duration
+	maxAttempts int
+	lastSweep   time.Time
+	wake        chan struct{}
+	mu          sync.Mutex // held while delivering, so nothing is sent twice
+}
+
+func newWebhookDispatcher(store webhookStore, client *http.Client, errorLog *log.Logger) *webhookDispatcher {
+	return &webhookDispatcher{
+		store:       store,
+		client:      client,
+		errorLog:    errorLog,
+		now:         time.Now,
+		backoff:     time.Minute,
+		maxAttempts: 8,
+		lastSweep:   time.Now().Add(-expiryLookback),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// errPrivateAddress is returned when a webhook resolves to an address on
+// the server's own network.
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// newWebhookClient returns the HTTP client webhooks are sent with. Unless
+// allowPrivate is set it refuses to connect to loopback, private and
+// link-local addresses, so that webhooks can't be used to reach services
+// behind the firewall. The check is made on the address actually dialled,
+// after DNS resolution. Redirects aren't followed.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     time.Minute,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookPayload is the JSON body posted to webhooks.
+type webhookPayload struct {
+	Event   string         `json:"event"`
+	Sent    time.Time      `json:"sent"`
+	Snippet webhookSnippet `json:"snippet"`
+}
+
+type webhookSnippet struct {
+	ID      int       `json:"id"`
+	URL     string    `json:"url"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	ForkOf  int       `json:"fork_of,omitempty"`
+	Hidden  bool      `json:"hidden"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// signWebhook returns the signature of a payload: the hex HMAC-SHA256, keyed
+// with the webhook's secret, of the timestamp, a dot and the body. The
+// timestamp is signed so that receivers can reject old payloads replayed
+// by someone who captured them.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify asks the dispatching goroutine to send whatever is due now
+// rather than at its next tick. The channel is buffered, so this never
+// blocks.
+func (d *webhookDispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue queues an event about a snippet for each of its author's
+// webhooks.
+func (d *webhookDispatcher) Enqueue(event string, s *models.Snippet) error {
+	if s.UserID == 0 {
+		return nil
+	}
+	hooks, err := d.store.ForUser(s.UserID)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+
+	for _, h := range hooks {
+		payload, err := json.Marshal(webhookPayload{
+			Event: event,
+			Sent:  d.now().UTC(),
+			Snippet: webhookSnippet{
+				ID:      s.ID,
+				URL:     fmt.Sprintf("%s/snippet/%d", h.Site, s.ID),
+				Title:   s.Title,
+				Content: s.Content,
+				ForkOf:  s.ParentID,
+				Hidden:  s.Hidden,
+				Created: s.Created.UTC(),
+				Expires: s.Expires.UTC(),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		// A snippet only expires once, however many times the sweep
+		// sees it.
+		err = d.store.Enqueue(h.ID, event, s.ID, payload, event == models.EventSnippetExpired)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.Notify()
+	return nil
+}
+
+// Sweep queues expiry events for the snippets which have expired since the
+// last sweep, and prunes old deliveries from the log.
+func (d *webhookDispatcher) Sweep() error {
+	now := d.now()
+	expired, err := d.store.Expired(d.lastSweep, now)
+	if err != nil {
+		return err
+	}
+	for _, s := range expired {
+		if err := d.Enqueue(models.EventSnippetExpired, s); err != nil {
+			return err
+		}
+	}
+	d.lastSweep = now
+
+	return d.store.Prune(now.Add(-deliveryRetention))
+}
+
+// Deliver sends every delivery which is due. It's safe to call while Run
+// is running.
+func (d *webhookDispatcher) Deliver() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	const batch = 20
+	for {
+		due, err := d.store.Due(d.now(), batch)
+		if err != nil {
+			return err
+		}
+		for _, dl := range due {
+			if err := d.attempt(dl); err != nil {
+				return err
+			}
+		}
+		if len(due) < batch {
+			return nil
+		}
+	}
+}
+
+// attempt sends a delivery once and records how it went, scheduling the
+// next attempt if it failed.
+func (d *webhookDispatcher) attempt(dl *models.WebhookDelivery) error {
+	status, err := d.send(dl)
+
+	now := d.now()
+	dl.Attempts++
+	dl.LastAttempt = now
+	dl.ResponseStatus = status
+	switch {
+	case err == nil:
+		dl.Status = models.DeliveryDelivered
+		dl.Error = ""
+	case dl.Attempts >= d.maxAttempts:
+		dl.Status = models.DeliveryFailed
+		dl.Error = err.Error()
+	default:
+		dl.Error = err.Error()
+		dl.NextAttempt = now.Add(d.backoff << (dl.Attempts - 1))
+	}
+
+	return d.store.Update(dl)
+}
+
+// send posts a delivery's payload to its webhook, returning the response
+// status. Anything but a 2xx response is a failure.
+func (d *webhookDispatcher) send(dl *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", dl.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Snippetbox-Webhook")
+	req.Header.Set("X-Snippetbox-Event", dl.Event)
+	req.Header.Set("X-Snippetbox-Delivery", strconv.Itoa(dl.ID))
+	req.Header.Set("X-Snippetbox-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Snippetbox-Signature", signWebhook(dl.Secret, timestamp, dl.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Run looks for expired snippets and sends the deliveries which are due
+// every interval, and whenever new events are queued. It never returns,
+// so it should be started in its own goroutine.
+func (d *webhookDispatcher) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Sweep(); err != nil {
+				d.errorLog.Print(err)
+			}
+		case <-d.wake:
+		}
+		if err := d.Deliver(); err != nil {
+			d.errorLog.Print(err)
+		}
+	}
+}
+
+// The snippetEvent helper queues an event for the webhooks of a snippet's
+// author. Failing to queue it is logged rather than failing the request
+// which caused it.
+func (app *application) snippetEvent(event string, s *models.Snippet) {
+	if err := app.webhookQueue.Enqueue(event, s); err != nil {
+		app.errorLog.Printf("webhook %s for snippet %d: %s", event, s.ID, err)
+	}
+}
+
+// The snippetEventByID helper is snippetEvent for a snippet which has to
+// be looked up first. Events go to the author, who can see all their
+// snippets, so the lookup isn't restricted.
+func (app *application) snippetEventByID(event string, id int) {
+	s, err := app.snippets.Get(id, models.Viewer{All: true})
+	if err != nil {
+		app.errorLog.Printf("webhook %s for snippet %d: %s", event, id, err)
+		return
+	}
+	app.snippetEvent(event, s)
+}
+
+func (app *application) renderWebhooks(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	user := app.authenticatedUser(r)
+
+	hooks, err := app.webhooks.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	deliveries, err := app.webhooks.Deliveries(user.ID, 50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "webhooks.page.tmpl", &templateData{
+		Deliveries: deliveries,
+		Form:       form,
+		Webhooks:   hooks,
+	})
+}
+
+func (app *application) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	app.renderWebhooks(w, r, forms.New(nil))
+}
+
+func (app *application) createWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("url")
+	form.MaxLength("url", 2000)
+	if u, err := url.Parse(form.Get("url")); form.Get("url") != "" &&
+		(err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "") {
+		form.Errors.Add("url", "This field must be an http or https URL")
+	}
+	if !form.Valid() {
+		app.renderWebhooks(w, r, form)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	hooks, err := app.webhooks.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if len(hooks) >= maxWebhooks {
+		form.Errors.Add("url", fmt.Sprintf("You can't have more than %d webhooks", maxWebhooks))
+		app.renderWebhooks(w, r, form)
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	_, err = app.webhooks.Insert(user.ID, form.Get("url"), hex.EncodeToString(b), absoluteURL(r, ""))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your webhook has been added. Use its secret to check the signatures of the events it receives.")
+	http.Redirect(w, r, "/user/webhooks", http.StatusSeeOther)
+}
+
+func (app *application) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.webhooks.Delete(app.authenticatedUser(r).ID, id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your webhook has been deleted.")
+	http.Redirect(w, r, "/user/webhooks", http.StatusSeeOther)
+}
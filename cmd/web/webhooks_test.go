@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// queueStore is an in-memory webhookStore.
+type queueStore struct {
+	hooks      []*models.Webhook
+	deliveries []*models.WebhookDelivery
+	expired    []*models.Snippet
+}
+
+func (s *queueStore) ForUser(userID int) ([]*models.Webhook, error) {
+	var hooks []*models.Webhook
+	for _, h := range s.hooks {
+		if h.UserID == userID {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks, nil
+}
+
+func (s *queueStore) Enqueue(webhookID int, event string, snippetID int, payload []byte, once bool) error {
+	var hook *models.Webhook
+	for _, h := range s.hooks {
+		if h.ID == webhookID {
+			hook = h
+		}
+	}
+	for _, d := range s.deliveries {
+		if once && d.WebhookID == webhookID && d.SnippetID == snippetID && d.Event == event {
+			return nil
+		}
+	}
+	s.deliveries = append(s.deliveries, &models.WebhookDelivery{
+		ID: len(s.deliveries) + 1, WebhookID: webhookID, URL: hook.URL, Secret: hook.Secret,
+		Event: event, SnippetID: snippetID, Payload: payload, Status: models.DeliveryPending,
+	})
+	return nil
+}
+
+func (s *queueStore) Due(now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	var due []*models.WebhookDelivery
+	for _, d := range s.deliveries {
+		if d.Status == models.DeliveryPending && !d.NextAttempt.After(now) && len(due) < limit {
+			dl := *d
+			due = append(due, &dl)
+		}
+	}
+	return due, nil
+}
+
+func (s *queueStore) Update(d *models.WebhookDelivery) error {
+	dl := *d
+	s.deliveries[d.ID-1] = &dl
+	return nil
+}
+
+func (s *queueStore) Expired(since, until time.Time) ([]*models.Snippet, error) {
+	var expired []*models.Snippet
+	for _, sn := range s.expired {
+		if sn.Expires.After(since) && !sn.Expires.After(until) {
+			expired = append(expired, sn)
+		}
+	}
+	return expired, nil
+}
+
+func (s *queueStore) Prune(before time.Time) error {
+	return nil
+}
+
+// receiver is a webhook endpoint which checks signatures and fails as
+// many times as it's told to before accepting deliveries.
+type receiver struct {
+	*httptest.Server
+	secret string
+
+	mu       sync.Mutex
+	failures int
+	received []webhookPayload
+	badSig   int
+}
+
+func newReceiver(secret string) *receiver {
+	rc := &receiver{secret: secret}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-Snippetbox-Timestamp"), 10, 64)
+		if r.Header.Get("X-Snippetbox-Signature") != signWebhook(rc.secret, timestamp, body) {
+			rc.badSig++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if rc.failures > 0 {
+			rc.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var p webhookPayload
+		json.Unmarshal(body, &p)
+		rc.received = append(rc.received, p)
+	}))
+	return rc
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	rc := newReceiver("s3cret")
+	defer rc.Close()
+
+	store := &queueStore{hooks: []*models.Webhook{
+		{ID: 1, UserID: 7, URL: rc.URL, Secret: "s3cret", Site: "https://snippetbox.test"},
+	}}
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	d := newWebhookDispatcher(store, rc.Client(), log.New(new(bytes.Buffer), "", 0))
+	d.now = func() time.Time { return now }
+
+	// Snippets by users without webhooks aren't queued.
+	err := d.Enqueue(models.EventSnippetCreated, &models.Snippet{ID: 2, UserID: 8, Title: "Other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.deliveries) != 0 {
+		t.Fatalf("want no deliveries; got %d", len(store.deliveries))
+	}
+
+	err = d.Enqueue(models.EventSnippetCreated, &models.Snippet{ID: 3, UserID: 7, Title: "Runbook"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The receiver is down for the first two attempts, which are retried
+	// a minute and then two minutes later.
+	rc.failures = 2
+	for i, wait := range []time.Duration{0, time.Minute, 2 * time.Minute} {
+		now = now.Add(wait)
+		if err := d.Deliver(); err != nil {
+			t.Fatal(err)
+		}
+
+		dl := store.deliveries[0]
+		if dl.Attempts != i+1 {
+			t.Fatalf("want %d attempts; got %d", i+1, dl.Attempts)
+		}
+		if i < 2 {
+			if dl.Status != models.DeliveryPending || dl.ResponseStatus != http.StatusServiceUnavailable {
+				t.Fatalf("attempt %d: want pending after 503; got %s after %d", i+1, dl.Status, dl.ResponseStatus)
+			}
+			if want := now.Add(time.Minute << i); !dl.NextAttempt.Equal(want) {
+				t.Fatalf("attempt %d: want retry at %v; got %v", i+1, want, dl.NextAttempt)
+			}
+
+			// Nothing is sent again before the retry is due.
+			if err := d.Deliver(); err != nil {
+				t.Fatal(err)
+			}
+			if store.deliveries[0].Attempts != i+1 {
+				t.Fatalf("attempt %d: want no early retry", i+1)
+			}
+		}
+	}
+
+	dl := store.deliveries[0]
+	if dl.Status != models.DeliveryDelivered || dl.Error != "" {
+		t.Errorf("want delivered; got %s (%s)", dl.Status, dl.Error)
+	}
+	if rc.badSig != 0 {
+		t.Errorf("want every signature valid; got %d bad", rc.badSig)
+	}
+	if len(rc.received) != 1 {
+		t.Fatalf("want 1 payload received; got %d", len(rc.received))
+	}
+	p := rc.received[0]
+	if p.Event != models.EventSnippetCreated || p.Snippet.ID != 3 || p.Snippet.URL != "https://snippetbox.test/snippet/3" {
+		t.Errorf("unexpected payload %+v", p)
+	}
+}
+
+func TestWebhookDeliveryGivesUp(t *testing.T) {
+	rc := newReceiver("s3cret")
+	defer rc.Close()
+
+	// The webhook's secret has been changed at the receiver's end, so every
+	// delivery is refused.
+	store := &queueStore{hooks: []*models.Webhook{{ID: 1, UserID: 7, URL: rc.URL, Secret: "old"}}}
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	d := newWebhookDispatcher(store, rc.Client(), log.New(new(bytes.Buffer), "", 0))
+	d.now = func() time.Time { return now }
+	d.maxAttempts = 3
+
+	d.Enqueue(models.EventSnippetDeleted, &models.Snippet{ID: 3, UserID: 7})
+	for i := 0; i < 5; i++ {
+		if err := d.Deliver(); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Hour)
+	}
+
+	dl := store.deliveries[0]
+	if dl.Status != models.DeliveryFailed || dl.Attempts != 3 || dl.ResponseStatus != http.StatusUnauthorized {
+		t.Errorf("want failed after 3 attempts with 401; got %s after %d with %d", dl.Status, dl.Attempts, dl.ResponseStatus)
+	}
+}
+
+func TestWebhookSweep(t *testing.T) {
+	store := &queueStore{hooks: []*models.Webhook{{ID: 1, UserID: 7, URL: "https://example.com"}}}
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	d := newWebhookDispatcher(store, http.DefaultClient, log.New(new(bytes.Buffer), "", 0))
+	d.now = func() time.Time { return now }
+	d.lastSweep = now.Add(-time.Hour)
+
+	store.expired = []*models.Snippet{
+		{ID: 1, UserID: 7, Expires: now.Add(-2 * time.Hour)},
+		{ID: 2, UserID: 7, Expires: now.Add(-time.Minute)},
+		{ID: 3, UserID: 7, Expires: now.Add(time.Minute)},
+	}
+
+	if err := d.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.deliveries) != 1 || store.deliveries[0].SnippetID != 2 || store.deliveries[0].Event != models.EventSnippetExpired {
+		t.Fatalf("want an expiry of snippet 2 queued; got %+v", store.deliveries)
+	}
+
+	// A restarted dispatcher looks back over what it's already seen, but
+	// doesn't queue the same expiry twice.
+	now = now.Add(2 * time.Minute)
+	d.lastSweep = now.Add(-expiryLookback)
+	if err := d.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.deliveries) != 3 {
+		t.Fatalf("want 3 expiries queued; got %d", len(store.deliveries))
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	rc := newReceiver("s3cret")
+	defer rc.Close()
+
+	_, err := newWebhookClient(false).Post(rc.URL, "application/json", nil)
+	if err == nil {
+		t.Fatal("want error posting to loopback address; got nil")
+	}
+
+	resp, err := newWebhookClient(true).Post(rc.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
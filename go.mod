@@ -3,11 +3,17 @@ module github.com/ardianeffendi/snippetbox
 go 1.19
 
 require (
-	github.com/bmizerany/pat v0.0.0-20210406213842-e4b6760bdd6f // indirect
-	github.com/go-sql-driver/mysql v1.7.0 // indirect
-	github.com/golangcollege/sessions v1.2.0 // indirect
-	github.com/justinas/alice v1.2.0 // indirect
-	github.com/justinas/nosurf v1.1.1 // indirect
-	golang.org/x/crypto v0.0.0-20200317142112-1b76d66859c6 // indirect
+	github.com/bmizerany/pat v0.0.0-20210406213842-e4b6760bdd6f
+	github.com/fxamacker/cbor/v2 v2.5.0
+	github.com/go-sql-driver/mysql v1.7.0
+	github.com/golangcollege/sessions v1.2.0
+	github.com/justinas/alice v1.2.0
+	github.com/justinas/nosurf v1.1.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.0.0-20200317142112-1b76d66859c6
+)
+
+require (
+	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
 )
@@ -99,6 +99,15 @@ func (f *Form) PermittedValues(field string, opts ...string) {
 	f.Errors.Add(field, "This field is invalid")
 }
 
+// Implement a MatchesField method to check that a specific field in the form
+// has the same value as another one, such as a password confirmation. If the
+// check fails then add the appropriate message to the form errors.
+func (f *Form) MatchesField(field, other string) {
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(field, "This field does not match")
+	}
+}
+
 // Implement a Valid method which returns true if there are no errors.
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
@@ -0,0 +1,88 @@
+// Package mailer sends the application's emails. Mailer is implemented by
+// SMTP, which delivers through a mail server, and by Log, which just writes
+// the messages out so that nothing has to be configured in development and
+// tests.
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is the interface implemented by anything which can send a Message.
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// format renders the message as an RFC 5322 document.
+func format(from string, msg *Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// SMTP sends messages through an SMTP server. If Username is set it
+// authenticates with PLAIN auth, which net/smtp only allows over TLS or to
+// localhost.
+type SMTP struct {
+	Addr     string // host:port of the server
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the message to the SMTP server.
+func (m *SMTP) Send(msg *Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, format(m.From, msg))
+}
+
+// Log writes messages to an io.Writer, such as a file or os.Stdout, instead
+// of sending them. It is safe for concurrent use.
+type Log struct {
+	From string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLog returns a Log which writes to w.
+func NewLog(w io.Writer, from string) *Log {
+	return &Log{From: from, w: w}
+}
+
+// Send writes the message to the log followed by a separator line.
+func (m *Log) Send(msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "%s\r\n\r\n-----\r\n", format(m.From, msg))
+	return err
+}
@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	m := NewLog(buf, "Snippetbox <no-reply@example.com>")
+
+	err := m.Send(&Message{
+		To:      "alice@example.com",
+		Subject: "Hello",
+		Body:    "line one\nline two",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	for _, want := range []string{
+		"From: Snippetbox <no-reply@example.com>\r\n",
+		"To: alice@example.com\r\n",
+		"Subject: Hello\r\n",
+		"\r\n\r\nline one\r\nline two",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want message to contain %q; got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package markdown implements the small subset of Markdown which is allowed
+// in snippet comments: paragraphs, line breaks, fenced code blocks, inline
+// code, emphasis and links.
+//
+// The input is HTML-escaped before any formatting is applied, so the only
+// markup in the output is the markup generated here. Links are restricted to
+// the http, https and mailto schemes.
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+var (
+	codeRX   = regexp.MustCompile("`([^`\n]+)`")
+	boldRX   = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	italicRX = regexp.MustCompile(`\*([^*\n]+)\*`)
+	linkRX   = regexp.MustCompile(`\[([^\]\n]+)\]\(((?:https?://|mailto:)[^)\s]+)\)`)
+)
+
+// Render converts the Markdown source into sanitised HTML.
+func Render(src string) template.HTML {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+
+	var out strings.Builder
+	var para []string
+	var code []string
+	inCode := false
+
+	flush := func() {
+		if len(para) > 0 {
+			out.WriteString("<p>")
+			out.WriteString(strings.Join(para, "<br>\n"))
+			out.WriteString("</p>\n")
+			para = nil
+		}
+	}
+
+	for _, line := range strings.Split(src, "\n") {
+		// Fenced code blocks are copied through escaped but otherwise
+		// untouched.
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				out.WriteString("<pre><code>")
+				out.WriteString(html.EscapeString(strings.Join(code, "\n")))
+				out.WriteString("</code></pre>\n")
+				code = nil
+			} else {
+				flush()
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			code = append(code, line)
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		para = append(para, inline(line))
+	}
+
+	// An unterminated code block runs to the end of the text.
+	if inCode {
+		out.WriteString("<pre><code>")
+		out.WriteString(html.EscapeString(strings.Join(code, "\n")))
+		out.WriteString("</code></pre>\n")
+	}
+	flush()
+
+	return template.HTML(out.String())
+}
+
+// inline applies the span-level formatting to a single escaped line. Code
+// spans are cut out first so that their contents aren't formatted.
+func inline(line string) string {
+	var spans []string
+	parts := codeRX.Split(line, -1)
+	for _, m := range codeRX.FindAllStringSubmatch(line, -1) {
+		spans = append(spans, "<code>"+html.EscapeString(m[1])+"</code>")
+	}
+
+	var b strings.Builder
+	for i, part := range parts {
+		part = html.EscapeString(part)
+		part = linkRX.ReplaceAllString(part, `<a href="$2" rel="nofollow noopener">$1</a>`)
+		part = boldRX.ReplaceAllString(part, "<strong>$1</strong>")
+		part = italicRX.ReplaceAllString(part, "<em>$1</em>")
+		b.WriteString(part)
+		if i < len(spans) {
+			b.WriteString(spans[i])
+		}
+	}
+	return b.String()
+}
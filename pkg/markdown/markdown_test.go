@@ -0,0 +1,64 @@
+package markdown
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want template.HTML
+	}{
+		{
+			name: "Paragraphs",
+			src:  "one\ntwo\n\nthree",
+			want: "<p>one<br>\ntwo</p>\n<p>three</p>\n",
+		},
+		{
+			name: "Emphasis",
+			src:  "**bold** and *italic*",
+			want: "<p><strong>bold</strong> and <em>italic</em></p>\n",
+		},
+		{
+			name: "Inline code",
+			src:  "run `**not bold** <b>`",
+			want: "<p>run <code>**not bold** &lt;b&gt;</code></p>\n",
+		},
+		{
+			name: "Code block",
+			src:  "```\n<script>\n```",
+			want: "<pre><code>&lt;script&gt;</code></pre>\n",
+		},
+		{
+			name: "Link",
+			src:  "[docs](https://golang.org/)",
+			want: "<p><a href=\"https://golang.org/\" rel=\"nofollow noopener\">docs</a></p>\n",
+		},
+		{
+			name: "Unsafe link",
+			src:  "[x](javascript:alert(1))",
+			want: "<p>[x](javascript:alert(1))</p>\n",
+		},
+		{
+			name: "Quoted link",
+			src:  `[x](https://a.b/"onclick=")`,
+			want: "<p><a href=\"https://a.b/&#34;onclick=&#34;\" rel=\"nofollow noopener\">x</a></p>\n",
+		},
+		{
+			name: "HTML",
+			src:  "<img src=x onerror=alert(1)>",
+			want: "<p>&lt;img src=x onerror=alert(1)&gt;</p>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.src)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -9,20 +9,399 @@ var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrInvalidToken       = errors.New("models: invalid or expired token")
+	ErrTokenReused        = errors.New("models: token used after it was replaced")
+	ErrDuplicateSlug      = errors.New("models: duplicate slug")
+	ErrTakenDown          = errors.New("models: snippet taken down for legal reasons")
 )
 
+// Scopes of the single-use tokens which are emailed to users.
+const (
+	ScopePasswordReset = "password-reset"
+	ScopeVerification  = "verification"
+)
+
+// Reasons a snippet can be reported for.
+const (
+	ReasonSpam      = "spam"
+	ReasonAbuse     = "abuse"
+	ReasonMalware   = "malware"
+	ReasonPersonal  = "personal"
+	ReasonCopyright = "copyright"
+	ReasonIllegal   = "illegal"
+	ReasonOther     = "other"
+)
+
+// ReportReasons lists the reasons a snippet can be reported for, in the
+// order they're offered.
+var ReportReasons = []string{ReasonSpam, ReasonAbuse, ReasonMalware, ReasonPersonal, ReasonCopyright, ReasonIllegal, ReasonOther}
+
+// LegalReason reports whether a snippet hidden for the reason has been
+// taken down for legal reasons, rather than for breaking the rules.
+func LegalReason(reason string) bool {
+	return reason == ReasonCopyright || reason == ReasonIllegal
+}
+
+// Statuses of a report. Open reports are waiting in the moderation queue;
+// the others record what the moderator decided.
+const (
+	ReportOpen      = "open"
+	ReportHidden    = "hidden"
+	ReportDeleted   = "deleted"
+	ReportDismissed = "dismissed"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditSignup         = "user.signup"
+	AuditLogin          = "user.login"
+	AuditLoginFailed    = "user.login_failed"
+	AuditLogout         = "user.logout"
+	AuditPasswordChange = "user.password_change"
+	AuditPasswordReset  = "user.password_reset"
+	AuditEmailChange    = "user.email_change"
+	AuditRoleChange     = "user.role_change"
+	AuditUserDisable    = "user.disable"
+	AuditUserEnable     = "user.enable"
+	AuditUserDelete     = "user.delete"
+	AuditDeleteRequest  = "user.delete_request"
+	AuditDeleteCancel   = "user.delete_cancel"
+	AuditSnippetCreate  = "snippet.create"
+	AuditSnippetEdit    = "snippet.edit"
+	AuditSnippetShare   = "snippet.share"
+	AuditSnippetUnshare = "snippet.unshare"
+	AuditLinkCreate     = "snippet.link_create"
+	AuditLinkRevoke     = "snippet.link_revoke"
+	AuditSnippetImport  = "snippet.import"
+	AuditSnippetHide    = "snippet.hide"
+	AuditSnippetUnhide  = "snippet.unhide"
+	AuditSnippetDelete  = "snippet.delete"
+)
+
+// AuditActions lists the audit log actions, for filtering.
+var AuditActions = []string{
+	AuditSignup, AuditLogin, AuditLoginFailed, AuditLogout, AuditPasswordChange,
+	AuditPasswordReset, AuditEmailChange, AuditRoleChange, AuditUserDisable,
+	AuditUserEnable, AuditUserDelete, AuditDeleteRequest, AuditDeleteCancel,
+	AuditSnippetCreate, AuditSnippetEdit, AuditSnippetShare, AuditSnippetUnshare,
+	AuditLinkCreate, AuditLinkRevoke, AuditSnippetImport, AuditSnippetHide,
+	AuditSnippetUnhide, AuditSnippetDelete,
+}
+
 type Snippet struct {
+	ID           int
+	UserID       int
+	OrgID        int
+	ParentID     int
+	Title        string
+	Content      string
+	Created      time.Time
+	Updated      time.Time // when it was last edited, or Created
+	Expires      time.Time
+	Private      bool
+	Hidden       bool
+	HiddenReason string
+	Stars        int
+	Comments     int
+
+	// Forks and Editable are set by SnippetModel.Get: how many forks of
+	// the snippet the viewer can see, and whether they can change it.
+	Forks    int
+	Editable bool
+}
+
+// A Viewer is who snippets are being read for, which decides the ones they
+// can see. The zero Viewer is an anonymous visitor.
+type Viewer struct {
+	UserID int
+	// All is set for moderators on the pages they deal with reports from,
+	// where they can see every snippet, and for the application's own use.
+	All bool
+	// LinkSnippetID is the snippet a share link being followed is for.
+	LinkSnippetID int
+}
+
+// Levels of access a snippet can be shared with.
+const (
+	AccessRead = "read"
+	AccessEdit = "edit"
+)
+
+// AccessLevels lists the levels of access a snippet can be shared with.
+var AccessLevels = []string{AccessRead, AccessEdit}
+
+// Share is a user's access to a snippet which its owner has shared with
+// them.
+type Share struct {
+	SnippetID int
+	UserID    int
+	UserName  string
+	Email     string
+	Access    string
+	Created   time.Time
+}
+
+// ShareLink is a link to a snippet which lets anyone who has it see the
+// snippet until it expires, is revoked, or has been viewed MaxViews times.
+// A MaxViews of 0 means there's no limit.
+type ShareLink struct {
+	ID        int
+	SnippetID int
+	CreatedBy int
+	Created   time.Time
+	Expires   time.Time
+	MaxViews  int
+	Views     int
+	Revoked   bool
+}
+
+type Comment struct {
+	ID        int
+	SnippetID int
+	UserID    int
+	UserName  string
+	ParentID  int
+	Line      int
+	Content   string
+	Created   time.Time
+}
+
+// Roles a user can have. Each role can do everything the ones before it
+// can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the roles in order of increasing power.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+type User struct {
+	ID        int
+	Name      string
+	Email     string
+	Password  []byte
+	Created   time.Time
+	Verified  bool
+	TwoFactor bool
+	Role      string
+	Disabled  bool
+
+	// DeleteAfter is when the account is due to be deleted at the user's
+	// request, or zero if it isn't. AnonymiseSnippets is whether their
+	// snippets are to be kept without their name rather than deleted.
+	DeleteAfter       time.Time
+	AnonymiseSnippets bool
+}
+
+// PersonalData is everything held about a user, for them to download.
+// It maps the name of each kind of data to its records, each of which
+// maps field names to values.
+type PersonalData map[string][]map[string]interface{}
+
+// HasRole reports whether the user has the role, or a more powerful one.
+func (u *User) HasRole(role string) bool {
+	return roleRank(u.Role) >= roleRank(role)
+}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// SystemStats are the totals shown in the admin area.
+type SystemStats struct {
+	Users          int
+	VerifiedUsers  int
+	DisabledUsers  int
+	Snippets       int
+	LiveSnippets   int
+	HiddenSnippets int
+	Comments       int
+	Stars          int
+}
+
+// Report is a user's report of a snippet which breaks the rules. The
+// snippet title and reporter name are filled in for display.
+type Report struct {
+	ID           int
+	SnippetID    int
+	SnippetTitle string
+	ReporterID   int
+	ReporterName string
+	Reason       string
+	Details      string
+	Created      time.Time
+	Status       string
+	ModeratorID  int
+	Decided      time.Time
+	Note         string
+}
+
+// AuditEvent is an entry in the audit log. ActorID is the user who did
+// it, or 0 if nobody was logged in; UserID and SnippetID are what it was
+// done to. Email, IP and UserAgent are personal data which can be redacted
+// without breaking the hash chain, because entries are chained over the
+// PIIDigest of them rather than the values themselves. Details mustn't
+// contain personal data.
+type AuditEvent struct {
+	ID        int
+	Created   time.Time
+	Action    string
+	ActorID   int
+	UserID    int
+	SnippetID int
+	Details   string
+	Email     string
+	IP        string
+	UserAgent string
+	Redacted  bool
+	PIIDigest string
+	PrevHash  string
+	Hash      string
+}
+
+// AuditFilter selects audit log entries. Zero fields match everything;
+// UserID matches entries done by or to the user.
+type AuditFilter struct {
+	Action string
+	UserID int
+	IP     string
+	Since  time.Time
+	Until  time.Time
+}
+
+// Snippet events sent to webhooks.
+const (
+	EventSnippetCreated = "snippet.created"
+	EventSnippetUpdated = "snippet.updated"
+	EventSnippetDeleted = "snippet.deleted"
+	EventSnippetExpired = "snippet.expired"
+)
+
+// Statuses of a webhook delivery. Pending deliveries are retried until
+// they succeed or run out of attempts.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a URL which a user's snippet events are posted to. Site is
+// the address of the application the webhook was registered on, which
+// links in the payloads point to.
+type Webhook struct {
 	ID      int
-	Title   string
-	Content string
+	UserID  int
+	URL     string
+	Secret  string
+	Site    string
 	Created time.Time
-	Expires time.Time
 }
 
-type User struct {
-	ID       int
-	Name     string
+// WebhookDelivery is an event queued for, or sent to, a webhook. The URL
+// and Secret are the webhook's. ResponseStatus and Error describe the
+// last attempt.
+type WebhookDelivery struct {
+	ID             int
+	WebhookID      int
+	URL            string
+	Secret         string
+	Event          string
+	SnippetID      int
+	Payload        []byte
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	Error          string
+	Created        time.Time
+	NextAttempt    time.Time
+	LastAttempt    time.Time
+}
+
+// Roles a member of an organisation can have. Owners manage the members
+// and invitations.
+const (
+	OrgRoleMember = "member"
+	OrgRoleOwner  = "owner"
+)
+
+// OrgRoles lists the organisation roles.
+var OrgRoles = []string{OrgRoleMember, OrgRoleOwner}
+
+// Organisation is a team whose snippets are only visible to its members.
+// When organisations are listed for a user, Role is the user's role in
+// each.
+type Organisation struct {
+	ID      int
+	Slug    string
+	Name    string
+	Created time.Time
+	Role    string
+}
+
+// Membership is a user's membership of an organisation.
+type Membership struct {
+	OrgID    int
+	UserID   int
+	UserName string
 	Email    string
-	Password []byte
-	Created  time.Time
+	Role     string
+	Joined   time.Time
+}
+
+// Invitation is an emailed invitation to join an organisation.
+type Invitation struct {
+	ID        int
+	OrgID     int
+	OrgName   string
+	Email     string
+	Role      string
+	InvitedBy int
+	Created   time.Time
+	Expires   time.Time
+}
+
+// Session is a logged in session. ID is the SHA-256 hash of the opaque
+// session ID kept in the user's cookie, so it can be shown and used to
+// revoke the session without letting anyone take it over.
+type Session struct {
+	ID        string
+	UserID    int
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+	IP        string
+	UserAgent string
+}
+
+// WebAuthnCredential is a passkey or security key registered by a user.
+type WebAuthnCredential struct {
+	ID        []byte
+	UserID    int
+	Name      string
+	PublicKey []byte
+	SignCount uint32
+	Created   time.Time
+	LastUsed  time.Time
+}
+
+// SnippetViews is a batch of views of a snippet on a single (UTC) day.
+// Visitors holds the anonymised identifiers of the visitors seen.
+type SnippetViews struct {
+	SnippetID int
+	Day       time.Time
+	Views     int
+	Visitors  []string
+}
+
+type DailyViews struct {
+	Day      time.Time
+	Views    int
+	Visitors int
 }
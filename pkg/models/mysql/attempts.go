@@ -0,0 +1,71 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginAttemptModel wraps a sql.DB connection pool and counts consecutive
+// failed logins, so that every instance of the application sees the same
+// counts. Keys name what is being counted, such as an account or an IP
+// address:
+//
+//	CREATE TABLE login_attempts (
+//	    attempt_key VARCHAR(255) NOT NULL PRIMARY KEY,
+//	    failures INTEGER NOT NULL,
+//	    last_failure DATETIME NOT NULL
+//	);
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Reserve counts an attempt for the key as a failure, unless allow, given
+// the failures so far and the time of the last one, refuses it. Failures
+// from before since are forgotten first. The count is read and updated
+// with the row locked, so attempts made at the same time are all counted.
+// It returns the count and whether the attempt was counted.
+func (m *LoginAttemptModel) Reserve(key string, at, since time.Time, allow func(int, time.Time) bool) (int, bool, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	// Make sure there's a row to lock.
+	_, err = tx.Exec("INSERT IGNORE INTO login_attempts (attempt_key, failures, last_failure) VALUES(?, 0, ?)", key, at.UTC())
+	if err != nil {
+		return 0, false, err
+	}
+
+	var failures int
+	var last time.Time
+	err = tx.QueryRow("SELECT failures, last_failure FROM login_attempts WHERE attempt_key = ? FOR UPDATE", key).Scan(&failures, &last)
+	if err != nil {
+		return 0, false, err
+	}
+	if last.Before(since) {
+		failures = 0
+	}
+	if !allow(failures, last) {
+		return failures, false, tx.Commit()
+	}
+
+	failures++
+	_, err = tx.Exec("UPDATE login_attempts SET failures = ?, last_failure = ? WHERE attempt_key = ?", failures, at.UTC(), key)
+	if err != nil {
+		return 0, false, err
+	}
+	return failures, true, tx.Commit()
+}
+
+// Refund takes back an attempt counted by Reserve.
+func (m *LoginAttemptModel) Refund(key string) error {
+	_, err := m.DB.Exec("UPDATE login_attempts SET failures = failures - 1 WHERE attempt_key = ? AND failures > 0", key)
+	return err
+}
+
+// Reset forgets the failures recorded for the key.
+func (m *LoginAttemptModel) Reset(key string) error {
+	_, err := m.DB.Exec("DELETE FROM login_attempts WHERE attempt_key = ?", key)
+	return err
+}
@@ -0,0 +1,263 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// AuditModel wraps a sql.DB connection pool and keeps the audit log of
+// security-relevant events. The log is append-only, and each entry holds
+// the hash of the one before it, so changing or removing an entry breaks
+// the chain from that point on:
+//
+//	CREATE TABLE audit_log (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    created DATETIME NOT NULL,
+//	    action VARCHAR(32) NOT NULL,
+//	    actor_id INTEGER NOT NULL,
+//	    user_id INTEGER NOT NULL,
+//	    snippet_id INTEGER NOT NULL,
+//	    details VARCHAR(255) NOT NULL,
+//	    email VARCHAR(255) NULL,
+//	    ip VARCHAR(45) NULL,
+//	    user_agent VARCHAR(255) NULL,
+//	    pii_nonce CHAR(32) NULL,
+//	    pii_digest CHAR(64) NOT NULL,
+//	    prev_hash CHAR(64) NOT NULL,
+//	    hash CHAR(64) NOT NULL
+//	);
+//	CREATE INDEX idx_audit_log_action ON audit_log(action);
+//	CREATE INDEX idx_audit_log_actor_id ON audit_log(actor_id);
+//	CREATE INDEX idx_audit_log_user_id ON audit_log(user_id);
+//	CREATE INDEX idx_audit_log_created ON audit_log(created);
+//
+// The database user the application runs as should only be granted INSERT
+// and SELECT on the table. Failing that, triggers stop entries being
+// deleted, or changed other than by redacting their personal data:
+//
+//	CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log FOR EACH ROW
+//	    SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+//	CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log FOR EACH ROW
+//	    IF NOT (NEW.email IS NULL AND NEW.ip IS NULL AND NEW.user_agent IS NULL AND NEW.pii_nonce IS NULL)
+//	        OR NEW.id <> OLD.id OR NEW.created <> OLD.created OR NEW.action <> OLD.action
+//	        OR NEW.actor_id <> OLD.actor_id OR NEW.user_id <> OLD.user_id
+//	        OR NEW.snippet_id <> OLD.snippet_id OR NEW.details <> OLD.details
+//	        OR NEW.pii_digest <> OLD.pii_digest OR NEW.prev_hash <> OLD.prev_hash OR NEW.hash <> OLD.hash
+//	    THEN
+//	        SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+//	    END IF;
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// piiDigest hashes an entry's personal data with a random nonce. The
+// nonce is deleted along with the data when it's redacted, after which
+// the digest gives nothing away, not even to someone guessing IP
+// addresses.
+func piiDigest(nonce, email, ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(nonce + "\x00" + email + "\x00" + ip + "\x00" + userAgent))
+	return hex.EncodeToString(sum[:])
+}
+
+// auditHash returns the hash of an entry, which covers everything but its
+// ID and personal data, and the hash of the entry before it.
+func auditHash(e *models.AuditEvent) string {
+	b, _ := json.Marshal([]interface{}{
+		e.PrevHash, e.Created.UTC().Unix(), e.Action, e.ActorID, e.UserID, e.SnippetID, e.Details, e.PIIDigest,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Insert appends an event to the log. Its Created time and hashes are
+// filled in.
+func (m *AuditModel) Insert(e *models.AuditEvent) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(b)
+
+	// DATETIME columns only keep whole seconds, so drop the rest now for
+	// the hash to match when the entry is read back.
+	e.Created = time.Now().UTC().Truncate(time.Second)
+	e.PIIDigest = piiDigest(nonce, e.Email, e.IP, e.UserAgent)
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the last entry makes concurrent inserts take turns, so each
+	// is chained to the one before.
+	err = tx.QueryRow(`SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1 FOR UPDATE`).Scan(&e.PrevHash)
+	if err == sql.ErrNoRows {
+		e.PrevHash = strings.Repeat("0", 64)
+	} else if err != nil {
+		return err
+	}
+	e.Hash = auditHash(e)
+
+	stmt := `INSERT INTO audit_log (created, action, actor_id, user_id, snippet_id, details,
+    email, ip, user_agent, pii_nonce, pii_digest, prev_hash, hash)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := tx.Exec(stmt, e.Created, e.Action, e.ActorID, e.UserID, e.SnippetID, e.Details,
+		e.Email, e.IP, e.UserAgent, nonce, e.PIIDigest, e.PrevHash, e.Hash)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.ID = int(id)
+
+	return tx.Commit()
+}
+
+const auditColumns = `id, created, action, actor_id, user_id, snippet_id, details,
+    email, ip, user_agent, pii_nonce, pii_digest, prev_hash, hash`
+
+// auditWhere returns the WHERE clause and arguments for a filter.
+func auditWhere(f models.AuditFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.Action != "" {
+		conds = append(conds, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.UserID != 0 {
+		conds = append(conds, "(actor_id = ? OR user_id = ?)")
+		args = append(args, f.UserID, f.UserID)
+	}
+	if f.IP != "" {
+		conds = append(conds, "ip = ?")
+		args = append(args, f.IP)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "created >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "created < ?")
+		args = append(args, f.Until.UTC())
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// scan reads the entries from rows, calling fn for each in turn, so that
+// the whole log never has to be held in memory.
+func (m *AuditModel) scan(rows *sql.Rows, fn func(*models.AuditEvent) error) error {
+	defer rows.Close()
+
+	for rows.Next() {
+		e := &models.AuditEvent{}
+		var email, ip, userAgent, nonce sql.NullString
+		err := rows.Scan(&e.ID, &e.Created, &e.Action, &e.ActorID, &e.UserID, &e.SnippetID, &e.Details,
+			&email, &ip, &userAgent, &nonce, &e.PIIDigest, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return err
+		}
+		e.Email, e.IP, e.UserAgent = email.String, ip.String, userAgent.String
+		e.Redacted = !nonce.Valid
+
+		// The nonce is only needed to check the digest, and isn't handed
+		// out with the rest.
+		if nonce.Valid && piiDigest(nonce.String, e.Email, e.IP, e.UserAgent) != e.PIIDigest {
+			e.PIIDigest = ""
+		}
+
+		if err = fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// List returns a page of the entries matching the filter, newest first.
+func (m *AuditModel) List(f models.AuditFilter, offset, limit int) ([]*models.AuditEvent, error) {
+	where, args := auditWhere(f)
+	rows, err := m.DB.Query(`SELECT `+auditColumns+` FROM audit_log`+where+`
+    ORDER BY id DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*models.AuditEvent{}
+	err = m.scan(rows, func(e *models.AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Each calls fn for every entry matching the filter, oldest first.
+func (m *AuditModel) Each(f models.AuditFilter, fn func(*models.AuditEvent) error) error {
+	where, args := auditWhere(f)
+	rows, err := m.DB.Query(`SELECT `+auditColumns+` FROM audit_log`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+	return m.scan(rows, fn)
+}
+
+// Verify walks the whole log checking the hash chain and the digests of
+// personal data which hasn't been redacted. It returns the number of
+// entries checked and the ID of the first one which doesn't match, or 0
+// if the log is intact.
+func (m *AuditModel) Verify() (int, int, error) {
+	checked, broken := 0, 0
+	prev := strings.Repeat("0", 64)
+
+	err := m.Each(models.AuditFilter{}, func(e *models.AuditEvent) error {
+		if broken != 0 {
+			return nil
+		}
+		checked++
+		if e.PrevHash != prev || e.PIIDigest == "" || auditHash(e) != e.Hash {
+			broken = e.ID
+		}
+		prev = e.Hash
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return checked, broken, nil
+}
+
+// Redact removes the personal data from every entry about a user, or
+// about logins to their email address, and returns how many entries were
+// changed. Each entry keeps its digest, so the hash chain can still be
+// verified.
+func (m *AuditModel) Redact(userID int, email string) (int, error) {
+	stmt := `UPDATE audit_log SET email = NULL, ip = NULL, user_agent = NULL, pii_nonce = NULL
+    WHERE (actor_id = ? OR user_id = ? OR email = ?) AND pii_nonce IS NOT NULL`
+	result, err := m.DB.Exec(stmt, userID, userID, email)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
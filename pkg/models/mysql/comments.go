@@ -0,0 +1,160 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// CommentModel wraps a sql.DB connection pool and manages the comments left
+// on snippets. Replies point at the comment they answer through parent_id,
+// and deleting a comment deletes its replies along with it. Comments on a
+// specific line of the snippet record its (1-based) number in line:
+//
+//	CREATE TABLE comments (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    snippet_id INTEGER NOT NULL,
+//	    user_id INTEGER NOT NULL,
+//	    parent_id INTEGER NULL,
+//	    line INTEGER NULL,
+//	    content TEXT NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE
+//	);
+//	CREATE INDEX idx_comments_snippet_id ON comments(snippet_id);
+type CommentModel struct {
+	DB *sql.DB
+}
+
+// Insert adds a new comment to a snippet. A parentID of 0 starts a new
+// thread, otherwise the comment is a reply to that comment. A line of 0
+// comments on the snippet as a whole.
+func (m *CommentModel) Insert(snippetID, userID, parentID, line int, content string) (int, error) {
+	// Store a NULL rather than 0 for top-level comments so that the foreign
+	// key constraint is satisfied.
+	var parent, lineNo sql.NullInt64
+	if parentID > 0 {
+		parent = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+	if line > 0 {
+		lineNo = sql.NullInt64{Int64: int64(line), Valid: true}
+	}
+
+	stmt := `INSERT INTO comments (snippet_id, user_id, parent_id, line, content, created)
+    VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+	result, err := m.DB.Exec(stmt, snippetID, userID, parent, lineNo, content)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get returns a specific comment based on its id.
+func (m *CommentModel) Get(id int) (*models.Comment, error) {
+	stmt := `SELECT comments.id, comments.snippet_id, comments.user_id, users.name,
+    COALESCE(comments.parent_id, 0), COALESCE(comments.line, 0), comments.content, comments.created
+    FROM comments JOIN users ON users.id = comments.user_id
+    WHERE comments.id = ?`
+
+	c := &models.Comment{}
+	err := m.DB.QueryRow(stmt, id).Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName,
+		&c.ParentID, &c.Line, &c.Content, &c.Created)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ForSnippet returns all the comments on a snippet in the order they were
+// made. Threading them is left to the caller.
+func (m *CommentModel) ForSnippet(snippetID int) ([]*models.Comment, error) {
+	stmt := `SELECT comments.id, comments.snippet_id, comments.user_id, users.name,
+    COALESCE(comments.parent_id, 0), COALESCE(comments.line, 0), comments.content, comments.created
+    FROM comments JOIN users ON users.id = comments.user_id
+    WHERE comments.snippet_id = ? ORDER BY comments.created, comments.id`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*models.Comment{}
+	for rows.Next() {
+		c := &models.Comment{}
+		err := rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName,
+			&c.ParentID, &c.Line, &c.Content, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Delete removes a comment and all of its replies, in one transaction.
+func (m *CommentModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = deleteCommentTrees(tx, "id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteCommentTrees deletes the comments matching a condition along with
+// every reply below them, however deep. The foreign key would do the same,
+// but isn't there in every database, and a reply left pointing at a
+// comment which has gone would never be shown but still be counted.
+func deleteCommentTrees(tx *sql.Tx, cond string, args ...interface{}) error {
+	level, err := queryIDs(tx, "SELECT id FROM comments WHERE "+cond, args...)
+	for err == nil && len(level) > 0 {
+		in := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(level)), ", ") + ")"
+		var replies []interface{}
+		replies, err = queryIDs(tx, "SELECT id FROM comments WHERE parent_id IN "+in, level...)
+		if err != nil {
+			break
+		}
+		_, err = tx.Exec("DELETE FROM comments WHERE id IN "+in, level...)
+		level = replies
+	}
+	return err
+}
+
+// queryIDs returns the IDs selected by a query, ready to be used as the
+// arguments of another.
+func queryIDs(tx *sql.Tx, stmt string, args ...interface{}) ([]interface{}, error) {
+	rows, err := tx.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []interface{}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
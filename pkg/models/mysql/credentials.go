@@ -0,0 +1,92 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// CredentialModel wraps a sql.DB connection pool and stores the WebAuthn
+// credentials (passkeys and security keys) users have registered. The ID is
+// chosen by the authenticator, and the public key is stored COSE encoded:
+//
+//	CREATE TABLE webauthn_credentials (
+//	    id VARBINARY(1023) NOT NULL,
+//	    user_id INTEGER NOT NULL,
+//	    name VARCHAR(100) NOT NULL,
+//	    public_key BLOB NOT NULL,
+//	    sign_count INTEGER UNSIGNED NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    last_used DATETIME NULL,
+//	    PRIMARY KEY (id(255))
+//	);
+//	CREATE INDEX idx_webauthn_credentials_user_id ON webauthn_credentials(user_id);
+type CredentialModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a newly registered credential for a user.
+func (m *CredentialModel) Insert(userID int, name string, id, publicKey []byte, signCount uint32) error {
+	stmt := `INSERT INTO webauthn_credentials (id, user_id, name, public_key, sign_count, created)
+    VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, id, userID, name, publicKey, signCount)
+	return err
+}
+
+// Get returns the credential with the given ID.
+func (m *CredentialModel) Get(id []byte) (*models.WebAuthnCredential, error) {
+	stmt := `SELECT id, user_id, name, public_key, sign_count, created, COALESCE(last_used, created)
+    FROM webauthn_credentials WHERE id = ?`
+
+	c := &models.WebAuthnCredential{}
+	err := m.DB.QueryRow(stmt, id).Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.SignCount, &c.Created, &c.LastUsed)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ForUser returns all of a user's credentials, oldest first.
+func (m *CredentialModel) ForUser(userID int) ([]*models.WebAuthnCredential, error) {
+	stmt := `SELECT id, user_id, name, public_key, sign_count, created, COALESCE(last_used, created)
+    FROM webauthn_credentials WHERE user_id = ? ORDER BY created`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []*models.WebAuthnCredential{}
+	for rows.Next() {
+		c := &models.WebAuthnCredential{}
+		err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.PublicKey, &c.SignCount, &c.Created, &c.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+// Used records a successful login with the credential and its new
+// signature counter.
+func (m *CredentialModel) Used(id []byte, signCount uint32) error {
+	stmt := `UPDATE webauthn_credentials SET sign_count = ?, last_used = UTC_TIMESTAMP() WHERE id = ?`
+	_, err := m.DB.Exec(stmt, signCount, id)
+	return err
+}
+
+// Delete removes one of a user's credentials. The user ID is part of the
+// condition so that users can only delete their own.
+func (m *CredentialModel) Delete(userID int, id []byte) error {
+	_, err := m.DB.Exec("DELETE FROM webauthn_credentials WHERE user_id = ? AND id = ?", userID, id)
+	return err
+}
@@ -0,0 +1,43 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// IdentityModel wraps a sql.DB connection pool and links users to the
+// accounts they log in with at an OpenID Connect provider. An account is
+// identified by the provider's issuer URL and its subject identifier:
+//
+//	CREATE TABLE user_identities (
+//	    issuer VARCHAR(255) NOT NULL,
+//	    subject VARCHAR(255) NOT NULL,
+//	    user_id INTEGER NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    PRIMARY KEY (issuer, subject)
+//	);
+//	ALTER TABLE user_identities ADD CONSTRAINT fk_user_identities_user
+//	    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Insert links the provider account to a user.
+func (m *IdentityModel) Insert(userID int, issuer, subject string) error {
+	stmt := `INSERT INTO user_identities (issuer, subject, user_id, created)
+    VALUES(?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, issuer, subject, userID)
+	return err
+}
+
+// Get returns the ID of the user linked to the provider account.
+func (m *IdentityModel) Get(issuer, subject string) (int, error) {
+	var userID int
+	err := m.DB.QueryRow("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?", issuer, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, models.ErrNoRecord
+	}
+	return userID, err
+}
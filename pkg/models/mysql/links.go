@@ -0,0 +1,137 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// ShareLinkModel wraps a sql.DB connection pool and stores the share links
+// minted for snippets. Each link holds a random secret, of which only a
+// SHA-256 hash is stored, as with tokens, so the links can't be made again
+// from the table or from the application's signing key alone. The row also
+// records whether the link is still good: its expiry, how many times it
+// may be and has been viewed, and whether it has been revoked. A max_views
+// of 0 means there's no limit:
+//
+//	CREATE TABLE share_links (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    snippet_id INTEGER NOT NULL,
+//	    secret_hash CHAR(64) NOT NULL,
+//	    created_by INTEGER NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    expires DATETIME NOT NULL,
+//	    max_views INTEGER NOT NULL DEFAULT 0,
+//	    views INTEGER NOT NULL DEFAULT 0,
+//	    revoked BOOLEAN NOT NULL DEFAULT FALSE
+//	);
+//	CREATE INDEX idx_share_links_snippet_id ON share_links(snippet_id);
+type ShareLinkModel struct {
+	DB *sql.DB
+}
+
+// Insert records a new share link for a snippet and returns it along with
+// its secret, which can't be had again afterwards. The expiry is truncated
+// to the second, which is all the database keeps.
+func (m *ShareLinkModel) Insert(snippetID, userID int, expires time.Time, maxViews int) (*models.ShareLink, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	l := &models.ShareLink{
+		SnippetID: snippetID,
+		CreatedBy: userID,
+		Created:   time.Now().UTC().Truncate(time.Second),
+		Expires:   expires.UTC().Truncate(time.Second),
+		MaxViews:  maxViews,
+	}
+
+	stmt := `INSERT INTO share_links (snippet_id, secret_hash, created_by, created, expires, max_views)
+    VALUES(?, ?, ?, ?, ?, ?)`
+	result, err := m.DB.Exec(stmt, l.SnippetID, hashToken(secret), l.CreatedBy, l.Created, l.Expires, l.MaxViews)
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+	l.ID = int(id)
+
+	return l, secret, nil
+}
+
+// ForSnippet returns a snippet's share links, newest first.
+func (m *ShareLinkModel) ForSnippet(snippetID int) ([]*models.ShareLink, error) {
+	stmt := `SELECT id, snippet_id, created_by, created, expires, max_views, views, revoked
+    FROM share_links WHERE snippet_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*models.ShareLink{}
+	for rows.Next() {
+		l := &models.ShareLink{}
+		err = rows.Scan(&l.ID, &l.SnippetID, &l.CreatedBy, &l.Created, &l.Expires, &l.MaxViews, &l.Views, &l.Revoked)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// Revoke stops a snippet's share link from working.
+func (m *ShareLinkModel) Revoke(snippetID, id int) error {
+	_, err := m.DB.Exec("UPDATE share_links SET revoked = TRUE WHERE snippet_id = ? AND id = ?", snippetID, id)
+	return err
+}
+
+// Use counts a view through a share link and returns the ID of the snippet
+// it's for. If the secret is wrong, or the link has expired, been revoked
+// or used up its views, ErrInvalidToken is returned. The check and the count are a single
+// statement, so a link can't be viewed more than it allows by using it
+// several times at once.
+func (m *ShareLinkModel) Use(id int, secret string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE share_links SET views = views + 1
+    WHERE id = ? AND secret_hash = ? AND NOT revoked AND expires > UTC_TIMESTAMP()
+        AND (max_views = 0 OR views < max_views)`
+	result, err := tx.Exec(stmt, id, hashToken(secret))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, models.ErrInvalidToken
+	}
+
+	var snippetID int
+	err = tx.QueryRow("SELECT snippet_id FROM share_links WHERE id = ?", id).Scan(&snippetID)
+	if err != nil {
+		return 0, err
+	}
+
+	return snippetID, tx.Commit()
+}
@@ -0,0 +1,318 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+	"github.com/go-sql-driver/mysql"
+)
+
+// OrgModel wraps a sql.DB connection pool and stores organisations, their
+// members and the invitations to join them. Like other emailed tokens,
+// invitations are stored as a SHA-256 hash:
+//
+//	CREATE TABLE orgs (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    slug VARCHAR(40) NOT NULL,
+//	    name VARCHAR(100) NOT NULL,
+//	    created DATETIME NOT NULL
+//	);
+//	ALTER TABLE orgs ADD CONSTRAINT orgs_uc_slug UNIQUE (slug);
+//
+//	CREATE TABLE org_members (
+//	    org_id INTEGER NOT NULL,
+//	    user_id INTEGER NOT NULL,
+//	    role VARCHAR(16) NOT NULL,
+//	    joined DATETIME NOT NULL,
+//	    PRIMARY KEY (org_id, user_id)
+//	);
+//	CREATE INDEX idx_org_members_user_id ON org_members(user_id);
+//
+//	CREATE TABLE org_invitations (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    org_id INTEGER NOT NULL,
+//	    hash CHAR(64) NOT NULL,
+//	    email VARCHAR(255) NOT NULL,
+//	    role VARCHAR(16) NOT NULL,
+//	    invited_by INTEGER NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    expires DATETIME NOT NULL
+//	);
+//	CREATE UNIQUE INDEX idx_org_invitations_hash ON org_invitations(hash);
+//	CREATE INDEX idx_org_invitations_org_id ON org_invitations(org_id);
+type OrgModel struct {
+	DB *sql.DB
+}
+
+// Insert creates an organisation with the user as its owner. If the slug
+// is taken, ErrDuplicateSlug is returned.
+func (m *OrgModel) Insert(name, slug string, ownerID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO orgs (slug, name, created) VALUES(?, ?, UTC_TIMESTAMP())`, slug, name)
+	if err != nil {
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "orgs_uc_slug") {
+				return 0, models.ErrDuplicateSlug
+			}
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO org_members (org_id, user_id, role, joined) VALUES(?, ?, ?, UTC_TIMESTAMP())`,
+		id, ownerID, models.OrgRoleOwner)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), tx.Commit()
+}
+
+// Get returns the organisation with the given ID.
+func (m *OrgModel) Get(id int) (*models.Organisation, error) {
+	o := &models.Organisation{}
+	err := m.DB.QueryRow(`SELECT id, slug, name, created FROM orgs WHERE id = ?`, id).
+		Scan(&o.ID, &o.Slug, &o.Name, &o.Created)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// GetBySlug returns the organisation with the given slug.
+func (m *OrgModel) GetBySlug(slug string) (*models.Organisation, error) {
+	o := &models.Organisation{}
+	err := m.DB.QueryRow(`SELECT id, slug, name, created FROM orgs WHERE slug = ?`, slug).
+		Scan(&o.ID, &o.Slug, &o.Name, &o.Created)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// ForUser returns the organisations the user is a member of, by name,
+// with the user's role in each.
+func (m *OrgModel) ForUser(userID int) ([]*models.Organisation, error) {
+	stmt := `SELECT orgs.id, orgs.slug, orgs.name, orgs.created, org_members.role FROM orgs
+    JOIN org_members ON org_members.org_id = orgs.id
+    WHERE org_members.user_id = ? ORDER BY orgs.name`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []*models.Organisation{}
+	for rows.Next() {
+		o := &models.Organisation{}
+		err = rows.Scan(&o.ID, &o.Slug, &o.Name, &o.Created, &o.Role)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, o)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// Role returns the user's role in the organisation, or ErrNoRecord if
+// they're not a member.
+func (m *OrgModel) Role(orgID, userID int) (string, error) {
+	var role string
+	err := m.DB.QueryRow(`SELECT role FROM org_members WHERE org_id = ? AND user_id = ?`, orgID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", models.ErrNoRecord
+	}
+	return role, err
+}
+
+// Members returns the members of the organisation, owners first.
+func (m *OrgModel) Members(orgID int) ([]*models.Membership, error) {
+	stmt := `SELECT org_members.org_id, org_members.user_id, users.name, users.email, org_members.role, org_members.joined
+    FROM org_members JOIN users ON users.id = org_members.user_id
+    WHERE org_members.org_id = ? ORDER BY org_members.role = 'owner' DESC, users.name`
+
+	rows, err := m.DB.Query(stmt, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*models.Membership{}
+	for rows.Next() {
+		mb := &models.Membership{}
+		err = rows.Scan(&mb.OrgID, &mb.UserID, &mb.UserName, &mb.Email, &mb.Role, &mb.Joined)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, mb)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// SetRole changes a member's role.
+func (m *OrgModel) SetRole(orgID, userID int, role string) error {
+	_, err := m.DB.Exec(`UPDATE org_members SET role = ? WHERE org_id = ? AND user_id = ?`, role, orgID, userID)
+	return err
+}
+
+// RemoveMember takes a user out of the organisation. Their snippets stay
+// with the organisation.
+func (m *OrgModel) RemoveMember(orgID, userID int) error {
+	_, err := m.DB.Exec(`DELETE FROM org_members WHERE org_id = ? AND user_id = ?`, orgID, userID)
+	return err
+}
+
+// Invite creates an invitation to join the organisation which is valid for
+// the given time, and returns its plaintext token. Inviting an address
+// again replaces the earlier invitation.
+func (m *OrgModel) Invite(orgID int, email, role string, invitedBy int, ttl time.Duration) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM org_invitations WHERE org_id = ? AND email = ?`, orgID, email)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO org_invitations (org_id, hash, email, role, invited_by, created, expires)
+    VALUES(?, ?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	_, err = tx.Exec(stmt, orgID, hashToken(plaintext), email, role, invitedBy, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, tx.Commit()
+}
+
+const invitationColumns = `org_invitations.id, org_invitations.org_id, orgs.name, org_invitations.email,
+    org_invitations.role, org_invitations.invited_by, org_invitations.created, org_invitations.expires`
+
+func scanInvitation(row rowScanner) (*models.Invitation, error) {
+	inv := &models.Invitation{}
+	err := row.Scan(&inv.ID, &inv.OrgID, &inv.OrgName, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.Created, &inv.Expires)
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Invitation returns the unexpired invitation with the given token, or
+// ErrInvalidToken.
+func (m *OrgModel) Invitation(token string) (*models.Invitation, error) {
+	stmt := `SELECT ` + invitationColumns + ` FROM org_invitations
+    JOIN orgs ON orgs.id = org_invitations.org_id
+    WHERE org_invitations.hash = ? AND org_invitations.expires > UTC_TIMESTAMP()`
+
+	inv, err := scanInvitation(m.DB.QueryRow(stmt, hashToken(token)))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrInvalidToken
+	}
+	return inv, err
+}
+
+// Invitations returns the organisation's unexpired invitations, newest
+// first.
+func (m *OrgModel) Invitations(orgID int) ([]*models.Invitation, error) {
+	stmt := `SELECT ` + invitationColumns + ` FROM org_invitations
+    JOIN orgs ON orgs.id = org_invitations.org_id
+    WHERE org_invitations.org_id = ? AND org_invitations.expires > UTC_TIMESTAMP()
+    ORDER BY org_invitations.created DESC`
+
+	rows, err := m.DB.Query(stmt, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*models.Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// DeleteInvitation withdraws one of the organisation's invitations.
+func (m *OrgModel) DeleteInvitation(orgID, id int) error {
+	_, err := m.DB.Exec(`DELETE FROM org_invitations WHERE org_id = ? AND id = ?`, orgID, id)
+	return err
+}
+
+// Accept uses up an invitation, making the user a member of its
+// organisation with the role it offered. Someone who is already a member
+// keeps their current role. It returns the organisation's ID, or
+// ErrInvalidToken if the invitation isn't valid.
+func (m *OrgModel) Accept(token string, userID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id, orgID int
+	var role string
+	stmt := `SELECT id, org_id, role FROM org_invitations
+    WHERE hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(token)).Scan(&id, &orgID, &role)
+	if err == sql.ErrNoRows {
+		return 0, models.ErrInvalidToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM org_invitations WHERE id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`INSERT IGNORE INTO org_members (org_id, user_id, role, joined) VALUES(?, ?, ?, UTC_TIMESTAMP())`,
+		orgID, userID, role)
+	if err != nil {
+		return 0, err
+	}
+
+	return orgID, tx.Commit()
+}
@@ -0,0 +1,98 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// personalQueries select a user's rows from each table which holds data
+// about them, by name. Secrets which are no use outside the application,
+// like password, token and recovery code hashes, are left out. Sessions
+// and the audit log are kept elsewhere, and are added by the caller.
+var personalQueries = []struct {
+	name, stmt string
+}{
+	{"account", `SELECT id, name, email, created, verified, totp_enabled AS two_factor, role, disabled,
+        delete_after, anonymise_snippets FROM users WHERE id = ?`},
+	{"snippets", `SELECT id, org_id, parent_id, title, content, created, expires, private, hidden, hidden_reason
+        FROM snippets WHERE user_id = ? ORDER BY id`},
+	{"comments", `SELECT id, snippet_id, parent_id, line, content, created FROM comments WHERE user_id = ? ORDER BY id`},
+	{"stars", `SELECT snippet_id, created FROM stars WHERE user_id = ? ORDER BY created`},
+	{"reports", `SELECT id, snippet_id, reason, details, created, status, decided FROM reports WHERE reporter_id = ? ORDER BY id`},
+	{"shared_with_you", `SELECT snippet_id, access, created FROM snippet_access WHERE user_id = ? ORDER BY created`},
+	{"share_links", `SELECT id, snippet_id, created, expires, max_views, views, revoked FROM share_links WHERE created_by = ? ORDER BY id`},
+	{"organisations", `SELECT orgs.slug, orgs.name, org_members.role, org_members.joined
+        FROM org_members JOIN orgs ON orgs.id = org_members.org_id WHERE org_members.user_id = ? ORDER BY org_members.joined`},
+	{"invitations_sent", `SELECT org_id, email, role, created, expires FROM org_invitations WHERE invited_by = ? ORDER BY id`},
+	{"webhooks", `SELECT id, url, site, created FROM webhooks WHERE user_id = ? ORDER BY id`},
+	{"passkeys", `SELECT name, sign_count, created, last_used FROM webauthn_credentials WHERE user_id = ? ORDER BY created`},
+	{"single_sign_on", `SELECT issuer, subject, created FROM user_identities WHERE user_id = ? ORDER BY created`},
+	{"recovery_codes", `SELECT COUNT(*) AS remaining FROM recovery_codes WHERE user_id = ?`},
+	{"tokens", `SELECT scope, expiry FROM tokens WHERE user_id = ? ORDER BY expiry`},
+	{"remembered_devices", `SELECT expiry, replaced FROM remember_tokens WHERE user_id = ? ORDER BY expiry`},
+}
+
+// PersonalData returns everything the database holds about a user, for
+// them to download.
+func (m *UserModel) PersonalData(id int) (models.PersonalData, error) {
+	data := models.PersonalData{}
+	for _, q := range personalQueries {
+		records, err := queryRecords(m.DB, q.stmt, id)
+		if err != nil {
+			return nil, err
+		}
+		data[q.name] = records
+	}
+	return data, nil
+}
+
+// queryRecords runs a query and returns its rows as maps of column names
+// to values. Text comes back from the driver as bytes, and booleans as
+// TINYINTs, so they're turned back into strings and bools.
+func queryRecords(db *sql.DB, stmt string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		record := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			switch v := values[i].(type) {
+			case []byte:
+				record[col.Name()] = string(v)
+			case int64:
+				if col.DatabaseTypeName() == "TINYINT" {
+					record[col.Name()] = v != 0
+				} else {
+					record[col.Name()] = v
+				}
+			default:
+				record[col.Name()] = v
+			}
+		}
+		records = append(records, record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
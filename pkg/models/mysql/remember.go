@@ -0,0 +1,159 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// RememberTokenModel wraps a sql.DB connection pool and manages the tokens
+// which keep users logged in when they tick "remember me". Each token can
+// be used once, and is replaced by a new one in the same series when it
+// is. Replaced tokens are kept until the series expires, so that if one is
+// presented again, which means it was copied, the whole series is ended.
+// Only SHA-256 hashes of the tokens are stored. The session ID is the hash
+// of the session the series last logged in, so that signing the session
+// out can end the series too:
+//
+//	CREATE TABLE remember_tokens (
+//	    hash CHAR(64) NOT NULL PRIMARY KEY,
+//	    series CHAR(32) NOT NULL,
+//	    user_id INTEGER NOT NULL,
+//	    session_id CHAR(64) NOT NULL DEFAULT '',
+//	    expiry DATETIME NOT NULL,
+//	    replaced DATETIME NULL
+//	);
+//	CREATE INDEX idx_remember_tokens_series ON remember_tokens(series);
+//	CREATE INDEX idx_remember_tokens_user_id ON remember_tokens(user_id);
+type RememberTokenModel struct {
+	DB *sql.DB
+}
+
+// A token replaced less than this long ago may be presented again without
+// counting as reuse. Browsers can send several requests with the old token
+// before they get the response which replaces it.
+const rememberGrace = 30 * time.Second
+
+func newRememberToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// New starts a series of tokens for the user which lasts for ttl, and
+// returns the series and its first token.
+func (m *RememberTokenModel) New(userID int, ttl time.Duration) (string, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	series := base64.RawURLEncoding.EncodeToString(b)[:22]
+
+	plaintext, err := newRememberToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	stmt := `INSERT INTO remember_tokens (hash, series, user_id, expiry)
+    VALUES(?, ?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	_, err = m.DB.Exec(stmt, hashToken(plaintext), series, userID, int(ttl.Seconds()))
+	if err != nil {
+		return "", "", err
+	}
+
+	return series, plaintext, nil
+}
+
+// Rotate uses up a token and returns the user it belongs to, its series
+// and the token replacing it. If the token was replaced only moments ago
+// the new token is "", as the browser will already have been given it. If
+// it was replaced earlier, the series is ended and models.ErrTokenReused
+// returned along with the user ID. Unknown and expired tokens give
+// models.ErrInvalidToken.
+func (m *RememberTokenModel) Rotate(plaintext string) (int, string, string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var series string
+	var expiry time.Time
+	var replaced sql.NullTime
+	stmt := `SELECT user_id, series, expiry, replaced FROM remember_tokens
+    WHERE hash = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(plaintext)).Scan(&userID, &series, &expiry, &replaced)
+	if err == sql.ErrNoRows {
+		return 0, "", "", models.ErrInvalidToken
+	} else if err != nil {
+		return 0, "", "", err
+	}
+
+	if replaced.Valid {
+		if time.Since(replaced.Time) < rememberGrace {
+			return userID, series, "", nil
+		}
+
+		_, err = tx.Exec("DELETE FROM remember_tokens WHERE series = ?", series)
+		if err != nil {
+			return 0, "", "", err
+		}
+		if err = tx.Commit(); err != nil {
+			return 0, "", "", err
+		}
+		return userID, series, "", models.ErrTokenReused
+	}
+
+	next, err := newRememberToken()
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	_, err = tx.Exec("UPDATE remember_tokens SET replaced = UTC_TIMESTAMP() WHERE hash = ?", hashToken(plaintext))
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	// The new token expires with the series, so a series can't be kept
+	// going forever.
+	stmt = `INSERT INTO remember_tokens (hash, series, user_id, expiry) VALUES(?, ?, ?, ?)`
+	_, err = tx.Exec(stmt, hashToken(next), series, userID, expiry)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, "", "", err
+	}
+
+	return userID, series, next, nil
+}
+
+// SetSession records the session the series has logged in.
+func (m *RememberTokenModel) SetSession(series, sessionID string) error {
+	_, err := m.DB.Exec("UPDATE remember_tokens SET session_id = ? WHERE series = ?", sessionID, series)
+	return err
+}
+
+// DeleteForSession ends the series which logged in one of the user's
+// sessions. A session which isn't the user's is left alone.
+func (m *RememberTokenModel) DeleteForSession(userID int, sessionID string) error {
+	_, err := m.DB.Exec("DELETE FROM remember_tokens WHERE user_id = ? AND session_id = ?", userID, sessionID)
+	return err
+}
+
+// DeleteAllForUser ends all of the user's series except the one which
+// logged in the given session, which may be "" to end them all. Expired
+// tokens are cleared out at the same time.
+func (m *RememberTokenModel) DeleteAllForUser(userID int, exceptSession string) error {
+	stmt := `DELETE FROM remember_tokens
+    WHERE (user_id = ? AND (session_id = '' OR session_id <> ?)) OR expiry <= UTC_TIMESTAMP()`
+	_, err := m.DB.Exec(stmt, userID, exceptSession)
+	return err
+}
@@ -0,0 +1,114 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// ReportModel wraps a sql.DB connection pool and stores users' reports of
+// snippets. Reports stay open until a moderator decides what to do, and
+// the decision, who made it and why is kept with the report:
+//
+//	CREATE TABLE reports (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    snippet_id INTEGER NOT NULL,
+//	    reporter_id INTEGER NOT NULL,
+//	    reason VARCHAR(32) NOT NULL,
+//	    details TEXT NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    status VARCHAR(16) NOT NULL DEFAULT 'open',
+//	    moderator_id INTEGER NULL,
+//	    decided DATETIME NULL,
+//	    note TEXT NULL
+//	);
+//	CREATE INDEX idx_reports_status_created ON reports(status, created);
+//	CREATE INDEX idx_reports_snippet_id ON reports(snippet_id);
+type ReportModel struct {
+	DB *sql.DB
+}
+
+// reportColumns is the column list selected by queries which return whole
+// reports. Deleted snippets and users leave the title and name blank.
+const reportColumns = `reports.id, reports.snippet_id, COALESCE(snippets.title, ''),
+    reports.reporter_id, COALESCE(users.name, ''), reports.reason, reports.details, reports.created,
+    reports.status, COALESCE(reports.moderator_id, 0), COALESCE(reports.decided, reports.created),
+    COALESCE(reports.note, '')`
+
+const reportJoins = `LEFT JOIN snippets ON snippets.id = reports.snippet_id
+    LEFT JOIN users ON users.id = reports.reporter_id`
+
+func (m *ReportModel) query(stmt string, args ...interface{}) ([]*models.Report, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*models.Report{}
+	for rows.Next() {
+		r := &models.Report{}
+		err = rows.Scan(&r.ID, &r.SnippetID, &r.SnippetTitle, &r.ReporterID, &r.ReporterName,
+			&r.Reason, &r.Details, &r.Created, &r.Status, &r.ModeratorID, &r.Decided, &r.Note)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// Insert records a report. A user who already has an open report on the
+// snippet can't add another, and gets false back.
+func (m *ReportModel) Insert(snippetID, reporterID int, reason, details string) (bool, error) {
+	stmt := `INSERT INTO reports (snippet_id, reporter_id, reason, details, created)
+    SELECT ?, ?, ?, ?, UTC_TIMESTAMP() FROM DUAL
+    WHERE NOT EXISTS (SELECT 1 FROM reports WHERE snippet_id = ? AND reporter_id = ? AND status = 'open')`
+
+	result, err := m.DB.Exec(stmt, snippetID, reporterID, reason, details, snippetID, reporterID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// Get returns a single report.
+func (m *ReportModel) Get(id int) (*models.Report, error) {
+	reports, err := m.query(`SELECT `+reportColumns+` FROM reports `+reportJoins+` WHERE reports.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, models.ErrNoRecord
+	}
+	return reports[0], nil
+}
+
+// Open returns the open reports, oldest first, so the queue is worked
+// through in order.
+func (m *ReportModel) Open() ([]*models.Report, error) {
+	return m.query(`SELECT ` + reportColumns + ` FROM reports ` + reportJoins + `
+    WHERE reports.status = 'open' ORDER BY reports.created, reports.id`)
+}
+
+// Decided returns the most recently decided reports.
+func (m *ReportModel) Decided(limit int) ([]*models.Report, error) {
+	return m.query(`SELECT `+reportColumns+` FROM reports `+reportJoins+`
+    WHERE reports.status <> 'open' ORDER BY reports.decided DESC, reports.id DESC LIMIT ?`, limit)
+}
+
+// Decide closes all the open reports on a snippet with the moderator's
+// decision.
+func (m *ReportModel) Decide(snippetID, moderatorID int, status, note string) error {
+	stmt := `UPDATE reports SET status = ?, moderator_id = ?, decided = UTC_TIMESTAMP(), note = ?
+    WHERE snippet_id = ? AND status = 'open'`
+
+	_, err := m.DB.Exec(stmt, status, moderatorID, note, snippetID)
+	return err
+}
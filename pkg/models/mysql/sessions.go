@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// SessionModel wraps a sql.DB connection pool and stores logged in
+// sessions, so they can be listed and revoked. Sessions are keyed by the
+// hash of their ID:
+//
+//	CREATE TABLE sessions (
+//	    id CHAR(64) NOT NULL PRIMARY KEY,
+//	    user_id INTEGER NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    last_seen DATETIME NOT NULL,
+//	    expires DATETIME NOT NULL,
+//	    ip VARCHAR(45) NOT NULL,
+//	    user_agent VARCHAR(255) NOT NULL
+//	);
+//	CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+//	CREATE INDEX idx_sessions_expires ON sessions(expires);
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Insert stores a new session.
+func (m *SessionModel) Insert(s *models.Session) error {
+	stmt := `INSERT INTO sessions (id, user_id, created, last_seen, expires, ip, user_agent)
+    VALUES(?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.Exec(stmt, s.ID, s.UserID, s.Created.UTC(), s.LastSeen.UTC(), s.Expires.UTC(), s.IP, s.UserAgent)
+	return err
+}
+
+// Get returns the session with the given ID, if it hasn't expired.
+func (m *SessionModel) Get(id string) (*models.Session, error) {
+	s := &models.Session{}
+
+	stmt := `SELECT id, user_id, created, last_seen, expires, ip, user_agent FROM sessions
+    WHERE id = ? AND expires > UTC_TIMESTAMP()`
+	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.Expires, &s.IP, &s.UserAgent)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Touch records that the session has just been used, and from where.
+func (m *SessionModel) Touch(id string, at time.Time, ip string) error {
+	_, err := m.DB.Exec("UPDATE sessions SET last_seen = ?, ip = ? WHERE id = ?", at.UTC(), ip, id)
+	return err
+}
+
+// ForUser returns the user's unexpired sessions, most recently used first.
+func (m *SessionModel) ForUser(userID int) ([]*models.Session, error) {
+	stmt := `SELECT id, user_id, created, last_seen, expires, ip, user_agent FROM sessions
+    WHERE user_id = ? AND expires > UTC_TIMESTAMP() ORDER BY last_seen DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.Session{}
+	for rows.Next() {
+		s := &models.Session{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Created, &s.LastSeen, &s.Expires, &s.IP, &s.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete removes one of the user's sessions. Deleting a session which
+// doesn't exist, or belongs to someone else, does nothing.
+func (m *SessionModel) Delete(userID int, id string) error {
+	_, err := m.DB.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
+
+// DeleteAllForUser removes all of the user's sessions except the one with
+// the given ID, which may be empty to remove them all.
+func (m *SessionModel) DeleteAllForUser(userID int, except string) error {
+	_, err := m.DB.Exec("DELETE FROM sessions WHERE user_id = ? AND id <> ?", userID, except)
+	return err
+}
+
+// DeleteExpired removes the sessions which have expired.
+func (m *SessionModel) DeleteExpired() error {
+	_, err := m.DB.Exec("DELETE FROM sessions WHERE expires <= UTC_TIMESTAMP()")
+	return err
+}
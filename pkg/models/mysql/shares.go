@@ -0,0 +1,69 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// ShareModel wraps a sql.DB connection pool and manages the users a
+// snippet's author has shared it with. Each user is given read or edit
+// access; the readable and editable conditions in SnippetModel are what
+// enforce it:
+//
+//	CREATE TABLE snippet_access (
+//	    snippet_id INTEGER NOT NULL,
+//	    user_id INTEGER NOT NULL,
+//	    access VARCHAR(8) NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    PRIMARY KEY (snippet_id, user_id)
+//	);
+//	CREATE INDEX idx_snippet_access_user_id ON snippet_access(user_id);
+type ShareModel struct {
+	DB *sql.DB
+}
+
+// Set shares a snippet with a user, or changes the access they already
+// have to it.
+func (m *ShareModel) Set(snippetID, userID int, access string) error {
+	stmt := `INSERT INTO snippet_access (snippet_id, user_id, access, created)
+    VALUES(?, ?, ?, UTC_TIMESTAMP())
+    ON DUPLICATE KEY UPDATE access = VALUES(access)`
+	_, err := m.DB.Exec(stmt, snippetID, userID, access)
+	return err
+}
+
+// Delete stops sharing a snippet with a user.
+func (m *ShareModel) Delete(snippetID, userID int) error {
+	_, err := m.DB.Exec("DELETE FROM snippet_access WHERE snippet_id = ? AND user_id = ?", snippetID, userID)
+	return err
+}
+
+// ForSnippet returns the users a snippet has been shared with, by name.
+func (m *ShareModel) ForSnippet(snippetID int) ([]*models.Share, error) {
+	stmt := `SELECT snippet_access.snippet_id, snippet_access.user_id, users.name, users.email,
+        snippet_access.access, snippet_access.created
+    FROM snippet_access JOIN users ON users.id = snippet_access.user_id
+    WHERE snippet_access.snippet_id = ? ORDER BY users.name`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []*models.Share{}
+	for rows.Next() {
+		sh := &models.Share{}
+		err = rows.Scan(&sh.SnippetID, &sh.UserID, &sh.UserName, &sh.Email, &sh.Access, &sh.Created)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, sh)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
@@ -2,29 +2,143 @@ package mysql
 
 import (
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/ardianeffendi/snippetbox/pkg/models"
 )
 
 // Define a SnippetModel type which wraps a sql.DB connection pool.
+//
+// Snippets record the user who created them and, for forks, the snippet they
+// were copied from. Hidden snippets have been taken down by a moderator, and
+// are left out of every listing. If they were hidden because of a report,
+// hidden_reason records the reason it gave. Snippets which belong to an
+// organisation can only be seen by its members, and private snippets only
+// by their author; either can be shared with other users through the
+// snippet_access table (see ShareModel), or with anyone through a share
+// link (see ShareLinkModel). Forks keep the organisation and privacy of the
+// original. Editing a snippet records when in updated, which is NULL until
+// it has been.
+//
+// Which snippets a Viewer can see is decided by the readable condition,
+// which every method reading snippets applies:
+//
+//	ALTER TABLE snippets
+//	    ADD COLUMN user_id INTEGER NULL,
+//	    ADD COLUMN org_id INTEGER NULL,
+//	    ADD COLUMN parent_id INTEGER NULL,
+//	    ADD COLUMN private BOOLEAN NOT NULL DEFAULT FALSE,
+//	    ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE,
+//	    ADD COLUMN hidden_reason VARCHAR(32) NOT NULL DEFAULT '',
+//	    ADD COLUMN updated DATETIME NULL;
+//	CREATE INDEX idx_snippets_parent_id ON snippets(parent_id);
+//	CREATE INDEX idx_snippets_org_id ON snippets(org_id);
 type SnippetModel struct {
 	DB *sql.DB
 }
 
-// This will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+// snippetColumns is the column list selected by every query which returns
+// whole snippets, so that they can all be scanned by scanSnippet. The star
+// and comment counts are computed with correlated subqueries. Which forks
+// can be counted depends on the viewer, so Get counts them separately.
+const snippetColumns = `snippets.id, COALESCE(snippets.user_id, 0), COALESCE(snippets.org_id, 0), COALESCE(snippets.parent_id, 0),
+    snippets.title, snippets.content, snippets.created, COALESCE(snippets.updated, snippets.created), snippets.expires, snippets.private, snippets.hidden, snippets.hidden_reason,
+    (SELECT COUNT(*) FROM stars WHERE stars.snippet_id = snippets.id),
+    (SELECT COUNT(*) FROM comments WHERE comments.snippet_id = snippets.id)`
+
+// readable is the condition a snippet must meet for a viewer to see it, and
+// the one place where that is decided. Authors can always see their own
+// snippets, and those with All set can see every one. Everyone else can
+// only see the snippets meant for them which haven't been hidden. Its
+// placeholders are filled by readableArgs.
+const readable = `(? OR snippets.user_id = ? OR (NOT snippets.hidden AND ` + audience + `))`
+
+// audience is the condition that a snippet is meant for a viewer, whether
+// or not it has been hidden: public snippets are meant for everyone,
+// organisation snippets for its members, and any snippet for the users its
+// author has shared it with and for whoever follows a share link to it.
+// Its placeholders are filled by audienceArgs.
+const audience = `((NOT snippets.private AND (snippets.org_id IS NULL
+        OR snippets.org_id IN (SELECT org_id FROM org_members WHERE user_id = ?)))
+    OR snippets.id IN (SELECT snippet_id FROM snippet_access WHERE user_id = ?)
+    OR snippets.id = ?)`
+
+// editable is the condition that a user can change a snippet: they wrote
+// it, or it has been shared with them for editing. It takes the user's ID
+// twice.
+const editable = `(snippets.user_id = ?
+    OR snippets.id IN (SELECT snippet_id FROM snippet_access WHERE user_id = ? AND access = 'edit'))`
+
+// readableArgs returns the values for the placeholders in readable.
+func readableArgs(v models.Viewer) []interface{} {
+	return append([]interface{}{v.All, v.UserID}, audienceArgs(v)...)
+}
+
+// audienceArgs returns the values for the placeholders in audience.
+func audienceArgs(v models.Viewer) []interface{} {
+	return []interface{}{v.UserID, v.UserID, v.LinkSnippetID}
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSnippet copies a row selected with snippetColumns into a new Snippet.
+// Any columns selected after them are copied to extra.
+func scanSnippet(row rowScanner, extra ...interface{}) (*models.Snippet, error) {
+	s := &models.Snippet{}
+	dest := []interface{}{&s.ID, &s.UserID, &s.OrgID, &s.ParentID, &s.Title, &s.Content, &s.Created, &s.Updated, &s.Expires,
+		&s.Private, &s.Hidden, &s.HiddenReason, &s.Stars, &s.Comments}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// querySnippets runs a query selecting snippetColumns and collects the
+// resulting snippets.
+func querySnippets(db *sql.DB, stmt string, args ...interface{}) ([]*models.Snippet, error) {
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// This will insert a new snippet into the database. An orgID of 0 means the
+// snippet doesn't belong to an organisation; it's public unless private is
+// set.
+func (m *SnippetModel) Insert(userID, orgID int, private bool, title, content, expires string) (int, error) {
 	// Write the SQL statement we want to execute. It's split over two lines
 	// for readability (the reason being why it's surrounded with backquotes
 	// instead of normal double quotes).
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-    VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+	stmt := `INSERT INTO snippets (user_id, org_id, private, title, content, created, expires)
+    VALUES(?, NULLIF(?, 0), ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
 
 	// Use the exec() method on the embedded connection pool to execute the statement.
-	// The first parameter is the SQL statement, followed by the title, content
-	// and expiry values for the placeholder parameters. This method returns
-	// a sql.Result object, which containts some bacic information about what
-	// happened when the statement was executed.
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	// The first parameter is the SQL statement, followed by the owner,
+	// organisation, privacy, title, content and expiry values for the
+	// placeholder parameters. This method returns a sql.Result object, which
+	// containts some bacic information about what happened when the
+	// statement was executed.
+	result, err := m.DB.Exec(stmt, userID, orgID, private, title, content, expires)
 	if err != nil {
 		return 0, err
 	}
@@ -41,49 +155,67 @@ func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
 	return int(id), nil
 }
 
-// This will return a specific snippet based on its id.
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
-	// Write the SQL statement we want to execute. Again, it's split into
-	// two lines for readability.
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-    WHERE expires > UTC_TIMESTAMP() AND id = ?`
+// This will return a specific snippet based on its id, if the viewer can
+// see it. Hidden snippets are returned to their author and moderators with
+// Hidden set. Anyone else they were meant for gets ErrTakenDown if they
+// were hidden for legal reasons, and ErrNoRecord otherwise.
+func (m *SnippetModel) Get(id int, v models.Viewer) (*models.Snippet, error) {
+	// Write the SQL statement we want to execute. Besides the snippet, it
+	// selects whether the viewer can see it, whether it was meant for them
+	// and whether they can change it.
+	stmt := `SELECT ` + snippetColumns + `, ` + readable + `, ` + audience + `, ` + editable + `
+    FROM snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	args := append(readableArgs(v), audienceArgs(v)...)
+	args = append(args, v.UserID, v.UserID, id)
 
 	// Use the QueryRow() method on the connection pool to execute our
 	// SQL statement, passing in the untrusted id variable as the value for the
 	// placeholder parameter. This returns a pointer to a sql.Row object which
 	// holds the result from the database.
-	row := m.DB.QueryRow(stmt, id)
+	row := m.DB.QueryRow(stmt, args...)
 
-	// Initialise a pointer to a new zeroed Snippet struct.
-	s := &models.Snippet{}
-
-	// Use row.Scan() to copy the values from each field in sql.Row to the
-	// corresponding field in the Snippet struct. Notice that the arguments
-	// to row.Scan are *pointers* to the place you want to copy the data into,
-	// and the number of argumetns must be exactly the same as the number of
-	// columns returned by your statement. If the query returns no rows, then
+	// Use scanSnippet() to copy the values from each field in sql.Row to the
+	// fields of a new Snippet struct. If the query returns no rows, then
 	// row.Scan() will return a sql.ErrNoRows error. We check for that and return
 	// our own models.ErrNoRecord error instead of a Snippet object.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	var canRead, meantFor, canEdit bool
+	s, err := scanSnippet(row, &canRead, &meantFor, &canEdit)
 	if err == sql.ErrNoRows {
 		return nil, models.ErrNoRecord
 	} else if err != nil {
 		return nil, err
 	}
 
-	return s, nil
+	switch {
+	case canRead:
+		// Only the forks the viewer can see are counted, so that the count
+		// matches the list of them.
+		stmt = `SELECT COUNT(*) FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden AND parent_id = ? AND ` + readable
+		err = m.DB.QueryRow(stmt, append([]interface{}{id}, readableArgs(v)...)...).Scan(&s.Forks)
+		if err != nil {
+			return nil, err
+		}
+		s.Editable = canEdit
+		return s, nil
+	case meantFor && models.LegalReason(s.HiddenReason):
+		return nil, models.ErrTakenDown
+	default:
+		return nil, models.ErrNoRecord
+	}
 }
 
-// This will return the 10 most recently created snippets.
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+// This will return the 10 most recently created snippets the viewer can
+// see.
+func (m *SnippetModel) Latest(v models.Viewer) ([]*models.Snippet, error) {
 	// Write the SQL statement for retrieving latest 10 snippets.
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-    WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden AND ` + readable + ` ORDER BY created DESC LIMIT 10`
 
 	// Use the Query() method on the connection pool to execute our
 	// SQL statement. This returns a sql.Rows resultset containing the result of
 	// our query.
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.Query(stmt, readableArgs(v)...)
 	if err != nil {
 		return nil, err
 	}
@@ -104,10 +236,8 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 	// resultset automatically closes itself and frees-up the underlying
 	// database connection.
 	for rows.Next() {
-		// Create a pointer to a new zeroed Snippet struct
-		s := &models.Snippet{}
-
-		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		// Scan the row into a pointer to a new Snippet struct
+		s, err := scanSnippet(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -126,3 +256,256 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 
 	return snippets, nil
 }
+
+// List returns a page of the live snippets the viewer can see, newest
+// first, skipping the first offset snippets and returning at most limit.
+func (m *SnippetModel) List(offset, limit int, v models.Viewer) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden AND ` + readable + `
+    ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+
+	return querySnippets(m.DB, stmt, append(readableArgs(v), limit, offset)...)
+}
+
+// ByUser returns a page of the live snippets created by a user which the
+// viewer can see, newest first.
+func (m *SnippetModel) ByUser(userID, offset, limit int, v models.Viewer) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden AND user_id = ? AND ` + readable + `
+    ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+
+	args := append([]interface{}{userID}, readableArgs(v)...)
+	return querySnippets(m.DB, stmt, append(args, limit, offset)...)
+}
+
+// EachByUser calls fn for every live snippet created by a user, including
+// hidden and private ones, oldest first. It's for the user's own export,
+// so no viewer is needed.
+func (m *SnippetModel) EachByUser(userID int, fn func(*models.Snippet) error) error {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND user_id = ? ORDER BY created, id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Import saves snippets brought in from elsewhere, all in one transaction.
+// Snippets without an ID are inserted, keeping their creation and expiry
+// times, and given the ID they're inserted with. Those with one replace
+// the title and content of the snippet they name.
+func (m *SnippetModel) Import(snippets []*models.Snippet) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range snippets {
+		if s.ID != 0 {
+			_, err = tx.Exec("UPDATE snippets SET title = ?, content = ?, updated = UTC_TIMESTAMP() WHERE id = ?", s.Title, s.Content, s.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		stmt := `INSERT INTO snippets (user_id, org_id, private, title, content, created, expires)
+    VALUES(?, NULLIF(?, 0), ?, ?, ?, ?, ?)`
+		result, err := tx.Exec(stmt, s.UserID, s.OrgID, s.Private, s.Title, s.Content, s.Created.UTC(), s.Expires.UTC())
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		s.ID = int(id)
+	}
+
+	return tx.Commit()
+}
+
+// SharedWith returns a page of the live snippets which have been shared
+// with the viewer, newest first.
+func (m *SnippetModel) SharedWith(offset, limit int, v models.Viewer) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden
+        AND id IN (SELECT snippet_id FROM snippet_access WHERE user_id = ?) AND ` + readable + `
+    ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+
+	args := append([]interface{}{v.UserID}, readableArgs(v)...)
+	return querySnippets(m.DB, stmt, append(args, limit, offset)...)
+}
+
+// Fork copies the snippet with the given id into a new snippet owned by the
+// viewer, recording the original as its parent. The read and the insert
+// happen in a single transaction so the copy is consistent with the source
+// at the time of forking. Snippets have no language or tags, so the title
+// and content are all that's copied. The new snippet keeps the expiry and
+// privacy of the original, and belongs to the same organisation, if any.
+// Someone who isn't a member of that organisation, but can see the snippet
+// because it was shared with them, gets a private fork of their own
+// instead. Snippets the viewer can't see can't be forked.
+func (m *SnippetModel) Fork(id int, v models.Viewer) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	// Calling Rollback() after a successful Commit() is a no-op, so it's safe
+	// to defer it straight away to clean up on any of the error paths below.
+	defer tx.Rollback()
+
+	var title, content string
+	var orgID sql.NullInt64
+	var private bool
+	var expires time.Time
+	stmt := `SELECT org_id, private, title, content, expires FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden AND id = ? AND ` + readable + ` FOR UPDATE`
+	err = tx.QueryRow(stmt, append([]interface{}{id}, readableArgs(v)...)...).Scan(&orgID, &private, &title, &content, &expires)
+	if err == sql.ErrNoRows {
+		return 0, models.ErrNoRecord
+	} else if err != nil {
+		return 0, err
+	}
+
+	if orgID.Valid {
+		var member bool
+		stmt = `SELECT EXISTS(SELECT 1 FROM org_members WHERE org_id = ? AND user_id = ?)`
+		err = tx.QueryRow(stmt, orgID.Int64, v.UserID).Scan(&member)
+		if err != nil {
+			return 0, err
+		}
+		if !member {
+			orgID, private = sql.NullInt64{}, true
+		}
+	}
+
+	stmt = `INSERT INTO snippets (user_id, org_id, parent_id, private, title, content, created, expires)
+    VALUES(?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), ?)`
+	result, err := tx.Exec(stmt, v.UserID, orgID, id, private, title, content, expires)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(newID), nil
+}
+
+// Forks returns the live snippets which were forked from the snippet with
+// the given id and which the viewer can see, newest first.
+func (m *SnippetModel) Forks(id int, v models.Viewer) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden AND parent_id = ? AND ` + readable + `
+    ORDER BY created DESC`
+
+	return querySnippets(m.DB, stmt, append([]interface{}{id}, readableArgs(v)...)...)
+}
+
+// ByOrg returns a page of the live snippets belonging to an organisation
+// which the viewer can see, newest first.
+func (m *SnippetModel) ByOrg(orgID, offset, limit int, v models.Viewer) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE expires > UTC_TIMESTAMP() AND NOT hidden AND org_id = ? AND ` + readable + `
+    ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+
+	args := append([]interface{}{orgID}, readableArgs(v)...)
+	return querySnippets(m.DB, stmt, append(args, limit, offset)...)
+}
+
+// MostStarred returns the 10 live snippets the viewer can see which
+// received the most stars in the past week.
+func (m *SnippetModel) MostStarred(v models.Viewer) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    JOIN stars recent ON recent.snippet_id = snippets.id
+        AND recent.created > DATE_SUB(UTC_TIMESTAMP(), INTERVAL 7 DAY)
+    WHERE snippets.expires > UTC_TIMESTAMP() AND NOT snippets.hidden AND ` + readable + `
+    GROUP BY snippets.id
+    ORDER BY COUNT(recent.user_id) DESC, snippets.created DESC LIMIT 10`
+
+	return querySnippets(m.DB, stmt, readableArgs(v)...)
+}
+
+// Search returns a page of the snippets the viewer can see, including
+// expired and hidden ones, whose title contains the query, newest first.
+// It's for the admin area.
+func (m *SnippetModel) Search(query string, offset, limit int, v models.Viewer) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE title LIKE ? AND ` + readable + ` ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+
+	args := append([]interface{}{"%" + escapeLike(query) + "%"}, readableArgs(v)...)
+	return querySnippets(m.DB, stmt, append(args, limit, offset)...)
+}
+
+// Update changes a snippet's title and content. Callers must check that
+// the user is allowed to, with the Editable field set by Get.
+func (m *SnippetModel) Update(id int, title, content string) error {
+	_, err := m.DB.Exec("UPDATE snippets SET title = ?, content = ?, updated = UTC_TIMESTAMP() WHERE id = ?", title, content, id)
+	return err
+}
+
+// SetHidden hides a snippet from everyone but its author and moderators,
+// recording the report reason it was hidden for ("" if none), or shows it
+// again.
+func (m *SnippetModel) SetHidden(id int, hidden bool, reason string) error {
+	if !hidden {
+		reason = ""
+	}
+	_, err := m.DB.Exec("UPDATE snippets SET hidden = ?, hidden_reason = ? WHERE id = ?", hidden, reason, id)
+	return err
+}
+
+// Delete removes a snippet, along with its stars, comments and view
+// counts. Forks of it are kept, but no longer record it as their parent.
+func (m *SnippetModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		"DELETE FROM stars WHERE snippet_id = ?",
+		"DELETE FROM comments WHERE snippet_id = ?",
+		"DELETE FROM snippet_visitors WHERE snippet_id = ?",
+		"DELETE FROM snippet_views WHERE snippet_id = ?",
+		"DELETE FROM snippet_access WHERE snippet_id = ?",
+		"DELETE FROM share_links WHERE snippet_id = ?",
+		"UPDATE snippets SET parent_id = NULL WHERE parent_id = ?",
+		"DELETE FROM snippets WHERE id = ?",
+	} {
+		if _, err = tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// escapeLike escapes the wildcard characters in s for use in a LIKE
+// pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// The snippets and who they're shared with are set up by testdata/setup.sql.
+var testViewers = []struct {
+	name string
+	v    models.Viewer
+}{
+	{"Anonymous", models.Viewer{}},
+	{"Author", models.Viewer{UserID: 1}},
+	{"Org member", models.Viewer{UserID: 2}},
+	{"Shared to read", models.Viewer{UserID: 3}},
+	{"Shared to edit", models.Viewer{UserID: 4}},
+	{"Nobody in particular", models.Viewer{UserID: 5}},
+	{"Share link", models.Viewer{LinkSnippetID: 2}},
+	{"Share link to hidden", models.Viewer{LinkSnippetID: 6}},
+	{"All", models.Viewer{All: true}},
+}
+
+func TestSnippetModelGet(t *testing.T) {
+	// What each viewer gets for snippets 1 to 9: "read" or "edit" if they
+	// can see it, "none" for ErrNoRecord and "gone" for ErrTakenDown.
+	want := map[string][]string{
+		"Anonymous":            {"read", "none", "none", "none", "gone", "none", "read", "none", "read"},
+		"Author":               {"edit", "edit", "edit", "edit", "edit", "edit", "read", "none", "read"},
+		"Org member":           {"read", "none", "read", "none", "gone", "none", "read", "edit", "read"},
+		"Shared to read":       {"read", "none", "none", "read", "gone", "none", "read", "none", "edit"},
+		"Shared to edit":       {"read", "none", "none", "edit", "gone", "none", "read", "none", "read"},
+		"Nobody in particular": {"read", "none", "none", "none", "gone", "none", "read", "none", "read"},
+		"Share link":           {"read", "read", "none", "none", "gone", "none", "read", "none", "read"},
+		"Share link to hidden": {"read", "none", "none", "none", "gone", "none", "read", "none", "read"},
+		"All":                  {"read", "read", "read", "read", "read", "read", "read", "read", "read"},
+	}
+
+	m := SnippetModel{newTestDB(t)}
+
+	for _, tt := range testViewers {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for id := 1; id <= 9; id++ {
+				s, err := m.Get(id, tt.v)
+				switch {
+				case err == models.ErrNoRecord:
+					got = append(got, "none")
+				case err == models.ErrTakenDown:
+					got = append(got, "gone")
+				case err != nil:
+					t.Fatal(err)
+				case s.Editable:
+					got = append(got, "edit")
+				default:
+					got = append(got, "read")
+				}
+			}
+			if !reflect.DeepEqual(got, want[tt.name]) {
+				t.Errorf("want %v; got %v", want[tt.name], got)
+			}
+		})
+	}
+}
+
+func TestSnippetModelGetForks(t *testing.T) {
+	// Snippet 1 has a private fork by Bob and a public one by Carol. Only
+	// the forks the viewer can see are counted.
+	tests := []struct {
+		name string
+		v    models.Viewer
+		want int
+	}{
+		{"Anonymous", models.Viewer{}, 1},
+		{"Fork author", models.Viewer{UserID: 2}, 2},
+		{"All", models.Viewer{All: true}, 2},
+	}
+
+	m := SnippetModel{newTestDB(t)}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := m.Get(1, tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Forks != tt.want {
+				t.Errorf("want %d forks; got %d", tt.want, s.Forks)
+			}
+		})
+	}
+}
+
+func TestSnippetModelList(t *testing.T) {
+	// Hidden snippets are left out of listings, even for their author.
+	want := map[string][]int{
+		"Anonymous":            {9, 7, 1},
+		"Author":               {9, 7, 4, 3, 2, 1},
+		"Org member":           {9, 8, 7, 3, 1},
+		"Shared to read":       {9, 7, 4, 1},
+		"Shared to edit":       {9, 7, 4, 1},
+		"Nobody in particular": {9, 7, 1},
+		"Share link":           {9, 7, 2, 1},
+		"Share link to hidden": {9, 7, 1},
+		"All":                  {9, 8, 7, 4, 3, 2, 1},
+	}
+
+	m := SnippetModel{newTestDB(t)}
+
+	for _, tt := range testViewers {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets, err := m.List(0, 20, tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []int{}
+			for _, s := range snippets {
+				got = append(got, s.ID)
+			}
+			if !reflect.DeepEqual(got, want[tt.name]) {
+				t.Errorf("want %v; got %v", want[tt.name], got)
+			}
+		})
+	}
+}
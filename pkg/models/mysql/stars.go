@@ -0,0 +1,58 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// StarModel wraps a sql.DB connection pool and manages the snippets that
+// users have starred. Each user can star a snippet at most once:
+//
+//	CREATE TABLE stars (
+//	    user_id INTEGER NOT NULL,
+//	    snippet_id INTEGER NOT NULL,
+//	    created DATETIME NOT NULL,
+//	    PRIMARY KEY (user_id, snippet_id)
+//	);
+//	CREATE INDEX idx_stars_snippet_created ON stars(snippet_id, created);
+type StarModel struct {
+	DB *sql.DB
+}
+
+// Set stars or unstars a snippet on behalf of a user. It is idempotent:
+// starring an already starred snippet (or unstarring one which isn't starred)
+// leaves things as they are and isn't an error.
+func (m *StarModel) Set(userID, snippetID int, starred bool) error {
+	if !starred {
+		_, err := m.DB.Exec("DELETE FROM stars WHERE user_id = ? AND snippet_id = ?", userID, snippetID)
+		return err
+	}
+
+	// INSERT IGNORE skips the row if the (user_id, snippet_id) primary key
+	// already exists, which keeps the original starring time.
+	stmt := `INSERT IGNORE INTO stars (user_id, snippet_id, created)
+    VALUES(?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, userID, snippetID)
+	return err
+}
+
+// Starred reports whether the user has starred the snippet.
+func (m *StarModel) Starred(userID, snippetID int) (bool, error) {
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT true FROM stars WHERE user_id = ? AND snippet_id = ?)"
+	err := m.DB.QueryRow(stmt, userID, snippetID).Scan(&exists)
+	return exists, err
+}
+
+// ForUser returns the live snippets starred by the viewer, most recently
+// starred first. Snippets they can no longer see, say because they've
+// left the organisation they belong to, are left out.
+func (m *StarModel) ForUser(v models.Viewer) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    JOIN stars mine ON mine.snippet_id = snippets.id
+    WHERE mine.user_id = ? AND snippets.expires > UTC_TIMESTAMP() AND NOT snippets.hidden AND ` + readable + `
+    ORDER BY mine.created DESC`
+
+	return querySnippets(m.DB, stmt, append([]interface{}{v.UserID}, readableArgs(v)...)...)
+}
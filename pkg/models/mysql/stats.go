@@ -0,0 +1,35 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// StatsModel wraps a sql.DB connection pool and computes the totals shown
+// in the admin area.
+type StatsModel struct {
+	DB *sql.DB
+}
+
+// System returns the current totals.
+func (m *StatsModel) System() (*models.SystemStats, error) {
+	s := &models.SystemStats{}
+
+	stmt := `SELECT
+    (SELECT COUNT(*) FROM users),
+    (SELECT COUNT(*) FROM users WHERE verified),
+    (SELECT COUNT(*) FROM users WHERE disabled),
+    (SELECT COUNT(*) FROM snippets),
+    (SELECT COUNT(*) FROM snippets WHERE expires > UTC_TIMESTAMP() AND NOT hidden),
+    (SELECT COUNT(*) FROM snippets WHERE hidden),
+    (SELECT COUNT(*) FROM comments),
+    (SELECT COUNT(*) FROM stars)`
+	err := m.DB.QueryRow(stmt).Scan(&s.Users, &s.VerifiedUsers, &s.DisabledUsers,
+		&s.Snippets, &s.LiveSnippets, &s.HiddenSnippets, &s.Comments, &s.Stars)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
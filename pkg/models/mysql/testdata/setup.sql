@@ -0,0 +1,71 @@
+CREATE TABLE snippets (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    user_id INTEGER NULL,
+    org_id INTEGER NULL,
+    parent_id INTEGER NULL,
+    private BOOLEAN NOT NULL DEFAULT FALSE,
+    hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    hidden_reason VARCHAR(32) NOT NULL DEFAULT '',
+    updated DATETIME NULL
+);
+
+CREATE TABLE stars (
+    user_id INTEGER NOT NULL,
+    snippet_id INTEGER NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (user_id, snippet_id)
+);
+
+CREATE TABLE comments (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    parent_id INTEGER NULL,
+    line INTEGER NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE TABLE org_members (
+    org_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    joined DATETIME NOT NULL,
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE TABLE snippet_access (
+    snippet_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    access VARCHAR(8) NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (snippet_id, user_id)
+);
+
+-- Alice (1) wrote snippets 1 to 6. Bob (2) is a member of organisation 10.
+-- Snippet 4 is shared with Carol (3) to read and Dave (4) to edit, and
+-- snippet 6 with Carol to read. Snippet 7's author has deleted their
+-- account. Snippet 8 is Bob's private fork of snippet 1, and snippet 9 a
+-- public one by Carol.
+INSERT INTO snippets (id, title, content, created, expires, user_id, org_id, parent_id, private, hidden, hidden_reason) VALUES
+    (1, 'Public', 'a', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), 1, NULL, NULL, FALSE, FALSE, ''),
+    (2, 'Private', 'b', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), 1, NULL, NULL, TRUE, FALSE, ''),
+    (3, 'Team', 'c', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), 1, 10, NULL, FALSE, FALSE, ''),
+    (4, 'Shared', 'd', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), 1, NULL, NULL, TRUE, FALSE, ''),
+    (5, 'Taken down', 'e', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), 1, NULL, NULL, FALSE, TRUE, 'copyright'),
+    (6, 'Hidden', 'f', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), 1, NULL, NULL, TRUE, TRUE, 'spam'),
+    (7, 'Anonymous', 'g', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), NULL, NULL, NULL, FALSE, FALSE, ''),
+    (8, 'Private fork', 'a', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), 2, NULL, 1, TRUE, FALSE, ''),
+    (9, 'Public fork', 'a', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY), 3, NULL, 1, FALSE, FALSE, '');
+
+INSERT INTO org_members (org_id, user_id, role, joined) VALUES
+    (10, 2, 'member', UTC_TIMESTAMP());
+
+INSERT INTO snippet_access (snippet_id, user_id, access, created) VALUES
+    (4, 3, 'read', UTC_TIMESTAMP()),
+    (4, 4, 'edit', UTC_TIMESTAMP()),
+    (6, 3, 'read', UTC_TIMESTAMP());
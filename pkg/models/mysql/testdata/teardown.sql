@@ -0,0 +1,5 @@
+DROP TABLE snippets;
+DROP TABLE stars;
+DROP TABLE comments;
+DROP TABLE org_members;
+DROP TABLE snippet_access;
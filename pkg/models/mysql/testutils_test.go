@@ -0,0 +1,47 @@
+package mysql
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// newTestDB connects to the test database named by SNIPPETBOX_TEST_DSN,
+// creates the tables in testdata/setup.sql and drops them again when the
+// test finishes. Tests which use it are skipped if the variable isn't set.
+// The DSN needs parseTime=true&multiStatements=true, for example
+// "test_web:pass@/test_snippetbox?parseTime=true&multiStatements=true".
+func newTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SNIPPETBOX_TEST_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := os.ReadFile("./testdata/setup.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		script, err := os.ReadFile("./testdata/teardown.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	})
+
+	return db
+}
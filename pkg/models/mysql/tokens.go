@@ -0,0 +1,99 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// TokenModel wraps a sql.DB connection pool and manages the single-use,
+// expiring tokens which are emailed to users, such as password reset links.
+// Only a SHA-256 hash of each token is stored, so the tokens can't be used
+// by someone who gets hold of the table. The scope records what a token is
+// for, so a token issued for one purpose can't be used for another:
+//
+//	CREATE TABLE tokens (
+//	    hash CHAR(64) NOT NULL PRIMARY KEY,
+//	    user_id INTEGER NOT NULL,
+//	    scope VARCHAR(32) NOT NULL,
+//	    expiry DATETIME NOT NULL
+//	);
+//	CREATE INDEX idx_tokens_user_scope ON tokens(user_id, scope);
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// hashToken returns the hex encoded SHA-256 hash under which a token is
+// stored.
+func hashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// New creates a token for the user which is valid for the given time, and
+// returns its plaintext. The plaintext is 26 characters of base32 encoding
+// 16 random bytes, which is short enough to type in if need be.
+func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+
+	stmt := `INSERT INTO tokens (hash, user_id, scope, expiry)
+    VALUES(?, ?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+	_, err = m.DB.Exec(stmt, hashToken(plaintext), userID, scope, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// Consume checks that the token is valid for the scope and deletes it, so
+// that it can only ever be used once. It returns the ID of the user the
+// token was issued to, or models.ErrInvalidToken if the token doesn't exist
+// or has expired.
+func (m *TokenModel) Consume(plaintext, scope string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so that two concurrent requests can't both use the token.
+	var userID int
+	stmt := `SELECT user_id FROM tokens
+    WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(plaintext), scope).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, models.ErrInvalidToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM tokens WHERE hash = ?", hashToken(plaintext))
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// DeleteAllForUser removes all of a user's tokens for the scope, along with
+// any expired tokens of that scope.
+func (m *TokenModel) DeleteAllForUser(scope string, userID int) error {
+	stmt := `DELETE FROM tokens WHERE scope = ? AND (user_id = ? OR expiry <= UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, scope, userID)
+	return err
+}
@@ -0,0 +1,122 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// The two-factor authentication state of a user is kept alongside the rest
+// of their record. totp_last_counter is the time step of the last code that
+// was accepted, so that a code can't be used twice. The recovery codes are
+// stored as SHA-256 hashes, like tokens:
+//
+//	ALTER TABLE users
+//	    ADD COLUMN totp_secret VARCHAR(64) NULL,
+//	    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+//	    ADD COLUMN totp_last_counter BIGINT NOT NULL DEFAULT 0;
+//	CREATE TABLE recovery_codes (
+//	    user_id INTEGER NOT NULL,
+//	    hash CHAR(64) NOT NULL,
+//	    PRIMARY KEY (user_id, hash)
+//	);
+
+// EnableTwoFactor() method turns on two-factor authentication for a user
+// with the given TOTP secret, replacing any recovery codes they had with new
+// ones.
+func (m *UserModel) EnableTwoFactor(id int, secret string, recoveryCodes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = ?, totp_enabled = TRUE, totp_last_counter = 0 WHERE id = ?`
+	_, err = tx.Exec(stmt, secret, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, hash) VALUES(?, ?)", id, hashToken(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTwoFactor() method turns off two-factor authentication for a user
+// and removes their secret and recovery codes.
+func (m *UserModel) DisableTwoFactor(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_counter = 0 WHERE id = ?`
+	_, err = tx.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TwoFactorSecret() method returns the TOTP secret of a user who has
+// two-factor authentication turned on, or models.ErrNoRecord if they don't.
+func (m *UserModel) TwoFactorSecret(id int) (string, error) {
+	var secret string
+	stmt := `SELECT totp_secret FROM users WHERE id = ? AND totp_enabled = TRUE`
+	err := m.DB.QueryRow(stmt, id).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", models.ErrNoRecord
+	} else if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// UseTOTPCounter() method records that a code for the given time step has
+// been accepted. It reports false if a code for that step (or a later one)
+// has already been used, in which case the code must be refused.
+func (m *UserModel) UseTOTPCounter(id int, counter int64) (bool, error) {
+	stmt := `UPDATE users SET totp_last_counter = ? WHERE id = ? AND totp_last_counter < ?`
+	result, err := m.DB.Exec(stmt, counter, id, counter)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode() method checks a recovery code for a user and deletes it
+// so that it can't be used again. It reports whether the code was valid.
+func (m *UserModel) UseRecoveryCode(id int, code string) (bool, error) {
+	result, err := m.DB.Exec("DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?", id, hashToken(code))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
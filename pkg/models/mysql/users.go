@@ -3,22 +3,55 @@ package mysql
 import (
 	"database/sql"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ardianeffendi/snippetbox/pkg/models"
 	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// UserModel wraps a sql.DB connection pool. New users start out unverified
+// until they follow the link emailed to them; users who signed up before
+// verification was introduced are treated as verified. Everyone starts with
+// the "user" role, and disabled users can't log in. Users who ask for
+// their account to be deleted keep it until delete_after, in case they
+// change their mind:
+//
+//	ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+//	UPDATE users SET verified = TRUE;
+//	ALTER TABLE users
+//	    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user',
+//	    ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+//	ALTER TABLE users
+//	    ADD COLUMN delete_after DATETIME NULL,
+//	    ADD COLUMN anonymise_snippets BOOLEAN NOT NULL DEFAULT FALSE;
+//	CREATE INDEX idx_users_delete_after ON users(delete_after);
 type UserModel struct {
 	DB *sql.DB
 }
 
-// Insert() method adds a new record to the users table.
-func (m *UserModel) Insert(name, email, password string) error {
+// dummyHash returns a hash to compare against when logging in to an email
+// address which has no account. It has the same cost as real password
+// hashes, and is only generated when first needed.
+var dummyHash = func() func() []byte {
+	var once sync.Once
+	var hash []byte
+	return func() []byte {
+		once.Do(func() {
+			hash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), 12)
+		})
+		return hash
+	}
+}()
+
+// Insert() method adds a new, unverified, record to the users table and
+// returns its ID.
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	// Create a bcrypt hash of the plain-text password.
 	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	stmt := `INSERT INTO users(name, email, password, created)
@@ -30,16 +63,23 @@ func (m *UserModel) Insert(name, email, password string) error {
 	// 1062 and, if it is, we also check whether or not the error relates to
 	// our userc_uc_email key by checking the contents of the message string.
 	// If it does, we return an ErrDuplicateEmail error. Otherwise, we just
-	// return the original error (or nil if everything worked).
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPass))
+	// return the original error.
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPass))
 	if err != nil {
 		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
 			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "users_uc_email") {
-				return models.ErrDuplicateEmail
+				return 0, models.ErrDuplicateEmail
 			}
 		}
+		return 0, err
 	}
-	return err
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
 // Authenticate() method verifies whether a user exists with the provided
@@ -49,9 +89,13 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 	// If no matching email exists, we return the ErrInvalidCredentials error.
 	var id int
 	var hashedPass []byte
-	row := m.DB.QueryRow("SELECT id, password FROM users WHERE email = ?", email)
-	err := row.Scan(&id, &hashedPass)
+	var disabled bool
+	row := m.DB.QueryRow("SELECT id, password, disabled FROM users WHERE email = ?", email)
+	err := row.Scan(&id, &hashedPass, &disabled)
 	if err == sql.ErrNoRows {
+		// Do the same amount of work as for a real account, so how long
+		// the response takes doesn't reveal whether the email exists.
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
 		return 0, models.ErrInvalidCredentials
 	} else if err != nil {
 		return 0, err
@@ -66,15 +110,33 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		return 0, err
 	}
 
+	// Disabled users get the same error as a wrong password, so the login
+	// page doesn't reveal which accounts have been disabled.
+	if disabled {
+		return 0, models.ErrInvalidCredentials
+	}
+
 	return id, nil
 }
 
 // Get() method fetches the details for a specific user based on their ID.
 func (m *UserModel) Get(id int) (*models.User, error) {
-	s := &models.User{}
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+	s, err := scanUser(m.DB.QueryRow(stmt, id))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
 
-	stmt := `SELECT id, name, email, created FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Name, &s.Email, &s.Created)
+	return s, nil
+}
+
+// GetByEmail() method fetches the details for the user with the given email
+// address.
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
+	s, err := scanUser(m.DB.QueryRow(stmt, email))
 	if err == sql.ErrNoRows {
 		return nil, models.ErrNoRecord
 	} else if err != nil {
@@ -83,3 +145,237 @@ func (m *UserModel) Get(id int) (*models.User, error) {
 
 	return s, nil
 }
+
+// CheckPassword() method verifies the password of the user with the given ID,
+// returning ErrInvalidCredentials if it's wrong. bcrypt compares the hashes
+// in constant time, so the response time gives nothing away.
+func (m *UserModel) CheckPassword(id int, password string) error {
+	var hashedPass []byte
+	err := m.DB.QueryRow("SELECT password FROM users WHERE id = ?", id).Scan(&hashedPass)
+	if err == sql.ErrNoRows {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPass, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return models.ErrInvalidCredentials
+	}
+	return err
+}
+
+// UpdatePassword() method replaces a user's password with a bcrypt hash of
+// the new one.
+func (m *UserModel) UpdatePassword(id int, password string) error {
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.Exec("UPDATE users SET password = ? WHERE id = ?", string(hashedPass), id)
+	return err
+}
+
+// UpdateName() method changes a user's display name.
+func (m *UserModel) UpdateName(id int, name string) error {
+	_, err := m.DB.Exec("UPDATE users SET name = ? WHERE id = ?", name, id)
+	return err
+}
+
+// UpdateEmail() method changes a user's email address. The new address hasn't
+// been verified, so the user is marked unverified again. If another user
+// already has the address, ErrDuplicateEmail is returned.
+func (m *UserModel) UpdateEmail(id int, email string) error {
+	_, err := m.DB.Exec("UPDATE users SET email = ?, verified = FALSE WHERE id = ?", email, id)
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "users_uc_email") {
+			return models.ErrDuplicateEmail
+		}
+	}
+	return err
+}
+
+// SetVerified() method marks a user's email address as verified.
+func (m *UserModel) SetVerified(id int) error {
+	_, err := m.DB.Exec("UPDATE users SET verified = TRUE WHERE id = ?", id)
+	return err
+}
+
+// userColumns is the column list selected by queries which return whole
+// users, in the order scanUser expects.
+const userColumns = `id, name, email, created, verified, totp_enabled, role, disabled,
+    delete_after, anonymise_snippets`
+
+func scanUser(row rowScanner) (*models.User, error) {
+	u := &models.User{}
+	var deleteAfter sql.NullTime
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Verified, &u.TwoFactor, &u.Role, &u.Disabled,
+		&deleteAfter, &u.AnonymiseSnippets)
+	if err != nil {
+		return nil, err
+	}
+	u.DeleteAfter = deleteAfter.Time
+	return u, nil
+}
+
+// Search returns a page of users whose name or email address contains the
+// query, newest first.
+func (m *UserModel) Search(query string, offset, limit int) ([]*models.User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users
+    WHERE name LIKE ? OR email LIKE ? ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`
+
+	pattern := "%" + escapeLike(query) + "%"
+	rows, err := m.DB.Query(stmt, pattern, pattern, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SetRole changes a user's role.
+func (m *UserModel) SetRole(id int, role string) error {
+	_, err := m.DB.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	return err
+}
+
+// SetDisabled disables a user, so they can no longer log in, or enables
+// them again.
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	_, err := m.DB.Exec("UPDATE users SET disabled = ? WHERE id = ?", disabled, id)
+	return err
+}
+
+// ScheduleDeletion marks a user's account to be deleted once the given
+// time has passed, and whether their snippets are to be anonymised rather
+// than deleted along with it.
+func (m *UserModel) ScheduleDeletion(id int, after time.Time, anonymise bool) error {
+	_, err := m.DB.Exec("UPDATE users SET delete_after = ?, anonymise_snippets = ? WHERE id = ?", after.UTC(), anonymise, id)
+	return err
+}
+
+// CancelDeletion stops a user's account from being deleted.
+func (m *UserModel) CancelDeletion(id int) error {
+	_, err := m.DB.Exec("UPDATE users SET delete_after = NULL, anonymise_snippets = FALSE WHERE id = ?", id)
+	return err
+}
+
+// DueForDeletion returns the users whose accounts are due to be deleted.
+func (m *UserModel) DueForDeletion() ([]*models.User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users
+    WHERE delete_after <= UTC_TIMESTAMP() ORDER BY delete_after`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Delete removes a user along with everything they created: their
+// snippets, comments and stars, their webhooks, and their tokens, passkeys
+// and linked single sign-on accounts, and the reports about the snippets.
+// Their own reports are kept for the moderators' records, but without who
+// made them or what they wrote.
+// Sessions live in a separate store and have to be ended by the caller.
+func (m *UserModel) Delete(id int) error {
+	return m.Erase(id, false)
+}
+
+// Erase deletes a user as Delete does, but if anonymise is set their
+// public and team snippets are kept with no author instead. Nobody else
+// could read their private snippets, so those are always deleted. Either
+// way, who the snippets were shared with goes.
+func (m *UserModel) Erase(id int, anonymise bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec := func(stmts ...string) error {
+		for _, stmt := range stmts {
+			args := []interface{}{id}
+			if strings.Count(stmt, "?") == 2 {
+				args = append(args, id)
+			}
+			if _, err := tx.Exec(stmt, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if anonymise {
+		err = exec(
+			"DELETE FROM snippet_access WHERE snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)",
+			"DELETE FROM share_links WHERE snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)",
+			"UPDATE snippets SET user_id = NULL WHERE user_id = ? AND NOT private",
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replies to the user's comments go with them, as they would if the
+	// comments were deleted one by one.
+	err = deleteCommentTrees(tx, "user_id = ? OR snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)", id, id)
+	if err != nil {
+		return err
+	}
+
+	err = exec(
+		"DELETE FROM stars WHERE user_id = ? OR snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)",
+		"DELETE FROM snippet_views WHERE snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)",
+		"DELETE FROM snippet_visitors WHERE snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)",
+		"DELETE FROM snippet_access WHERE user_id = ? OR snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)",
+		"DELETE FROM share_links WHERE snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)",
+		"UPDATE reports SET reporter_id = 0, details = '' WHERE reporter_id = ?",
+		"DELETE FROM reports WHERE snippet_id IN (SELECT id FROM snippets WHERE user_id = ?)",
+		"UPDATE snippets SET parent_id = NULL WHERE parent_id IN (SELECT id FROM (SELECT id FROM snippets WHERE user_id = ?) mine)",
+		"DELETE FROM snippets WHERE user_id = ?",
+		"DELETE FROM tokens WHERE user_id = ?",
+		"DELETE FROM remember_tokens WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM webauthn_credentials WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)",
+		"DELETE FROM webhooks WHERE user_id = ?",
+		"DELETE FROM org_members WHERE user_id = ?",
+		"DELETE FROM org_invitations WHERE invited_by = ?",
+		"DELETE FROM users WHERE id = ?",
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,103 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// ViewModel wraps a sql.DB connection pool and stores the daily view counts
+// for each snippet. Visitors are only ever stored as identifiers which are
+// anonymised per day, so the unique visitor count of a day is exact but
+// visitors can't be followed from one day to the next:
+//
+//	CREATE TABLE snippet_views (
+//	    snippet_id INTEGER NOT NULL,
+//	    day DATE NOT NULL,
+//	    views INTEGER NOT NULL,
+//	    PRIMARY KEY (snippet_id, day)
+//	);
+//	CREATE TABLE snippet_visitors (
+//	    snippet_id INTEGER NOT NULL,
+//	    day DATE NOT NULL,
+//	    visitor CHAR(32) NOT NULL,
+//	    PRIMARY KEY (snippet_id, day, visitor)
+//	);
+type ViewModel struct {
+	DB *sql.DB
+}
+
+// AddBatch adds a batch of buffered views to the totals in a single
+// transaction.
+func (m *ViewModel) AddBatch(batch []*models.SnippetViews) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, v := range batch {
+		day := v.Day.UTC().Format("2006-01-02")
+
+		stmt := `INSERT INTO snippet_views (snippet_id, day, views) VALUES(?, ?, ?)
+    ON DUPLICATE KEY UPDATE views = views + VALUES(views)`
+		_, err = tx.Exec(stmt, v.SnippetID, day, v.Views)
+		if err != nil {
+			return err
+		}
+
+		for _, visitor := range v.Visitors {
+			stmt = `INSERT IGNORE INTO snippet_visitors (snippet_id, day, visitor) VALUES(?, ?, ?)`
+			_, err = tx.Exec(stmt, v.SnippetID, day, visitor)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Totals returns the total number of views of a snippet, and the sum of its
+// daily unique visitors.
+func (m *ViewModel) Totals(snippetID int) (int, int, error) {
+	var views, visitors int
+	stmt := `SELECT
+    (SELECT COALESCE(SUM(views), 0) FROM snippet_views WHERE snippet_id = ?),
+    (SELECT COUNT(*) FROM snippet_visitors WHERE snippet_id = ?)`
+	err := m.DB.QueryRow(stmt, snippetID, snippetID).Scan(&views, &visitors)
+	if err != nil {
+		return 0, 0, err
+	}
+	return views, visitors, nil
+}
+
+// Daily returns the views and unique visitors of a snippet for each day
+// since the given time, oldest first. Days without any views are left out.
+func (m *ViewModel) Daily(snippetID int, since time.Time) ([]*models.DailyViews, error) {
+	stmt := `SELECT v.day, v.views,
+    (SELECT COUNT(*) FROM snippet_visitors u WHERE u.snippet_id = v.snippet_id AND u.day = v.day)
+    FROM snippet_views v WHERE v.snippet_id = ? AND v.day >= ? ORDER BY v.day`
+
+	rows, err := m.DB.Query(stmt, snippetID, since.UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []*models.DailyViews{}
+	for rows.Next() {
+		d := &models.DailyViews{}
+		err := rows.Scan(&d.Day, &d.Views, &d.Visitors)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
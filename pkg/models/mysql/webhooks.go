@@ -0,0 +1,254 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/ardianeffendi/snippetbox/pkg/models"
+)
+
+// WebhookModel wraps a sql.DB connection pool and stores users' webhooks,
+// along with the queue of deliveries to them. Deliveries are kept after
+// they're sent, as the log shown to the user, until they're pruned:
+//
+//	CREATE TABLE webhooks (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    user_id INTEGER NOT NULL,
+//	    url VARCHAR(2000) NOT NULL,
+//	    secret CHAR(64) NOT NULL,
+//	    site VARCHAR(255) NOT NULL,
+//	    created DATETIME NOT NULL
+//	);
+//	CREATE INDEX idx_webhooks_user_id ON webhooks(user_id);
+//
+//	CREATE TABLE webhook_deliveries (
+//	    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+//	    webhook_id INTEGER NOT NULL,
+//	    event VARCHAR(32) NOT NULL,
+//	    snippet_id INTEGER NOT NULL,
+//	    payload MEDIUMTEXT NOT NULL,
+//	    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+//	    attempts INTEGER NOT NULL DEFAULT 0,
+//	    response_status INTEGER NOT NULL DEFAULT 0,
+//	    error VARCHAR(255) NOT NULL DEFAULT '',
+//	    created DATETIME NOT NULL,
+//	    next_attempt DATETIME NOT NULL,
+//	    last_attempt DATETIME NULL
+//	);
+//	CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt);
+//	CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, snippet_id, event);
+//	CREATE INDEX idx_webhook_deliveries_created ON webhook_deliveries(created);
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// deliveryLease is how long a delivery handed out by Due is kept from
+// other dispatchers while it's being sent.
+const deliveryLease = 5 * time.Minute
+
+// Insert registers a webhook for the user.
+func (m *WebhookModel) Insert(userID int, url, secret, site string) (int, error) {
+	stmt := `INSERT INTO webhooks (user_id, url, secret, site, created)
+    VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt, userID, url, secret, site)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// ForUser returns the user's webhooks, oldest first.
+func (m *WebhookModel) ForUser(userID int) ([]*models.Webhook, error) {
+	stmt := `SELECT id, user_id, url, secret, site, created FROM webhooks
+    WHERE user_id = ? ORDER BY id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*models.Webhook{}
+	for rows.Next() {
+		h := &models.Webhook{}
+		err = rows.Scan(&h.ID, &h.UserID, &h.URL, &h.Secret, &h.Site, &h.Created)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, h)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete removes one of the user's webhooks and its deliveries.
+func (m *WebhookModel) Delete(userID, id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM webhooks WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	_, err = tx.Exec(`DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Enqueue adds a delivery of an event to a webhook, to be sent straight
+// away. If once is true, nothing is added if the webhook has already had
+// the event for the snippet.
+func (m *WebhookModel) Enqueue(webhookID int, event string, snippetID int, payload []byte, once bool) error {
+	stmt := `INSERT INTO webhook_deliveries (webhook_id, event, snippet_id, payload, created, next_attempt)
+    SELECT ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP() FROM DUAL`
+	args := []interface{}{webhookID, event, snippetID, payload}
+	if once {
+		stmt += ` WHERE NOT EXISTS (SELECT 1 FROM webhook_deliveries
+        WHERE webhook_id = ? AND snippet_id = ? AND event = ?)`
+		args = append(args, webhookID, snippetID, event)
+	}
+
+	_, err := m.DB.Exec(stmt, args...)
+	return err
+}
+
+const deliveryColumns = `webhook_deliveries.id, webhook_deliveries.webhook_id, webhooks.url, webhooks.secret,
+    webhook_deliveries.event, webhook_deliveries.snippet_id, webhook_deliveries.payload, webhook_deliveries.status,
+    webhook_deliveries.attempts, webhook_deliveries.response_status, webhook_deliveries.error,
+    webhook_deliveries.created, webhook_deliveries.next_attempt,
+    COALESCE(webhook_deliveries.last_attempt, webhook_deliveries.created)`
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryDeliveries runs a query selecting deliveryColumns and collects the
+// resulting deliveries.
+func queryDeliveries(q queryer, stmt string, args ...interface{}) ([]*models.WebhookDelivery, error) {
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.WebhookDelivery{}
+	for rows.Next() {
+		d := &models.WebhookDelivery{}
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.Event, &d.SnippetID, &d.Payload, &d.Status,
+			&d.Attempts, &d.ResponseStatus, &d.Error, &d.Created, &d.NextAttempt, &d.LastAttempt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// Due returns up to limit pending deliveries whose next attempt is due,
+// oldest first. They're leased to the caller for a few minutes, so that
+// another dispatcher won't send them too, and the lease runs out if the
+// caller dies before recording how the attempt went.
+func (m *WebhookModel) Due(now time.Time, limit int) ([]*models.WebhookDelivery, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+    INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+    WHERE webhook_deliveries.status = 'pending' AND webhook_deliveries.next_attempt <= ?
+    ORDER BY webhook_deliveries.next_attempt, webhook_deliveries.id LIMIT ?
+    FOR UPDATE`
+
+	deliveries, err := queryDeliveries(tx, stmt, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	ids := make([]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		ids[i] = d.ID
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	_, err = tx.Exec(`UPDATE webhook_deliveries SET next_attempt = ? WHERE id IN (`+placeholders+`)`,
+		append([]interface{}{now.Add(deliveryLease).UTC()}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, tx.Commit()
+}
+
+// Update records the outcome of an attempt to send a delivery.
+func (m *WebhookModel) Update(d *models.WebhookDelivery) error {
+	stmt := `UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = ?,
+    next_attempt = ?, last_attempt = ? WHERE id = ?`
+
+	msg := d.Error
+	if len(msg) > 255 {
+		msg = msg[:255]
+	}
+	_, err := m.DB.Exec(stmt, d.Status, d.Attempts, d.ResponseStatus, msg,
+		d.NextAttempt.UTC(), d.LastAttempt.UTC(), d.ID)
+	return err
+}
+
+// Deliveries returns the most recent deliveries to the user's webhooks.
+func (m *WebhookModel) Deliveries(userID, limit int) ([]*models.WebhookDelivery, error) {
+	stmt := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries
+    INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+    WHERE webhooks.user_id = ?
+    ORDER BY webhook_deliveries.id DESC LIMIT ?`
+
+	return queryDeliveries(m.DB, stmt, userID, limit)
+}
+
+// Expired returns the snippets which expired after since and no later
+// than until, and belong to users with webhooks.
+func (m *WebhookModel) Expired(since, until time.Time) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + ` FROM snippets
+    WHERE expires > ? AND expires <= ? AND user_id IN (SELECT user_id FROM webhooks)
+    ORDER BY expires`
+
+	return querySnippets(m.DB, stmt, since.UTC(), until.UTC())
+}
+
+// Prune deletes finished deliveries created before the given time.
+func (m *WebhookModel) Prune(before time.Time) error {
+	stmt := `DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created < ?`
+	_, err := m.DB.Exec(stmt, before.UTC())
+	return err
+}
@@ -0,0 +1,342 @@
+// Package oidc implements the relying party side of OpenID Connect's
+// authorization code flow: provider discovery, PKCE, and verification of
+// the ID token returned by the token endpoint.
+//
+// Only what the application needs is supported. ID tokens must be signed
+// with RS256, which every provider is required to support, and the client
+// authenticates to the token endpoint with client_secret_basic.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("oidc: invalid ID token")
+	ErrExchange     = errors.New("oidc: code exchange failed")
+)
+
+// leeway allows for clock differences between us and the provider when
+// checking token timestamps.
+const leeway = time.Minute
+
+// encoding is the unpadded base64url encoding used by JWTs and PKCE.
+var encoding = base64.RawURLEncoding
+
+// A Provider is an OpenID provider which the application is registered with
+// as a client.
+type Provider struct {
+	Issuer        string
+	AuthURL       string
+	TokenURL      string
+	JWKSURL       string
+	ClientID      string
+	ClientSecret  string
+	Client        *http.Client
+	mu            sync.Mutex
+	keys          map[string]*rsa.PublicKey
+	keysRefreshed time.Time
+}
+
+// Claims holds the claims from a verified ID token which the application
+// uses.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        float64  `json:"exp"`
+	IssuedAt      float64  `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is the "aud" claim, which may be a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+// Discover fetches the provider's configuration from its well-known
+// discovery document.
+func Discover(ctx context.Context, issuer, clientID, clientSecret string) (*Provider, error) {
+	p := &Provider{Issuer: issuer, ClientID: clientID, ClientSecret: clientSecret, Client: http.DefaultClient}
+
+	var doc struct {
+		Issuer   string   `json:"issuer"`
+		AuthURL  string   `json:"authorization_endpoint"`
+		TokenURL string   `json:"token_endpoint"`
+		JWKSURL  string   `json:"jwks_uri"`
+		Algs     []string `json:"id_token_signing_alg_values_supported"`
+	}
+	err := p.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// The issuer in the document must be exactly the one we asked for,
+	// otherwise one provider could impersonate another.
+	if doc.Issuer != issuer {
+		return nil, fmt.Errorf("oidc: discovery document is for issuer %q, not %q", doc.Issuer, issuer)
+	}
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURL == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+
+	p.AuthURL = doc.AuthURL
+	p.TokenURL = doc.TokenURL
+	p.JWKSURL = doc.JWKSURL
+	return p, nil
+}
+
+// Random returns a random base64url string for use as a state, nonce or
+// PKCE code verifier.
+func Random() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for a code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return encoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the user to in order to log in with
+// the provider.
+func (p *Provider) AuthCodeURL(redirectURL, state, nonce, verifier string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode()
+}
+
+// Exchange swaps the authorization code the provider redirected back with
+// for an ID token, verifies it and returns its claims. The nonce and code
+// verifier must be the ones used to build the AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code, verifier, nonce string) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if err != nil || resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrExchange, resp.Status, body.Error)
+	}
+
+	return p.Verify(ctx, body.IDToken, nonce)
+}
+
+// Verify checks an ID token's signature and claims, and returns the claims.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	case !claims.Audience.contains(p.ClientID):
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.ClientID:
+		return nil, fmt.Errorf("%w: wrong authorized party", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case unix(claims.Expiry).Before(now.Add(-leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case unix(claims.IssuedAt).After(now.Add(leeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func unix(f float64) time.Time {
+	return time.Unix(int64(f), 0)
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := encoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// key returns the provider's signing key with the given ID. Providers
+// rotate their keys, so an unknown ID causes the key set to be fetched
+// again, though not more than once a minute.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysRefreshed) < time.Minute {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.JWKSURL, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err1 := encoding.DecodeString(k.N)
+		e, err2 := encoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	p.keysRefreshed = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,243 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIdP is a minimal OpenID provider. Its authorization endpoint logs in
+// a fixed user without asking, and redirects straight back with a code.
+type mockIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   encoding.EncodeToString(key.N.Bytes()),
+				"e":   encoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code, _ := Random()
+		idp.mu.Lock()
+		idp.codes[code] = q
+		idp.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		q, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+
+		id, secret, _ := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" ||
+			r.PostForm.Get("redirect_uri") != q.Get("redirect_uri") ||
+			CodeChallenge(r.PostForm.Get("code_verifier")) != q.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := map[string]interface{}{
+			"iss":            idp.URL,
+			"sub":            "user-1",
+			"aud":            "client",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          q.Get("nonce"),
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"name":           "Alice",
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, claims)})
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *mockIdP) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + encoding.EncodeToString(sig)
+}
+
+// login runs the flow the way the application does: redirect to the
+// provider, check the state it sends back, and exchange the code.
+func login(t *testing.T, p *Provider, nonce, verifier string) (*Claims, error) {
+	const redirectURL = "https://snippetbox.test/callback"
+	state, _ := Random()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(p.AuthCodeURL(redirectURL, state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loc.Query().Get("state"); got != state {
+		t.Fatalf("want state %q; got %q", state, got)
+	}
+
+	// The callback doesn't check the verifier it's given: a wrong one
+	// should be caught by the provider.
+	return p.Exchange(context.Background(), redirectURL, loc.Query().Get("code"), verifier, nonce)
+}
+
+func TestLogin(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+
+	p, err := Discover(context.Background(), idp.URL, "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, _ := Random()
+	verifier, _ := Random()
+	claims, err := login(t, p, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Name != "Alice" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestLoginRejects(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+
+	p, err := Discover(context.Background(), idp.URL, "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   error
+	}{
+		{"Expired", map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}, ErrInvalidToken},
+		{"Wrong audience", map[string]interface{}{"aud": "someone-else"}, ErrInvalidToken},
+		{"Several audiences", map[string]interface{}{"aud": []string{"client", "other"}}, ErrInvalidToken},
+		{"Wrong issuer", map[string]interface{}{"iss": "https://evil.example.com"}, ErrInvalidToken},
+		{"Wrong nonce", map[string]interface{}{"nonce": "replayed"}, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.claims = tt.claims
+			defer func() { idp.claims = nil }()
+
+			nonce, _ := Random()
+			verifier, _ := Random()
+			_, err := login(t, p, nonce, verifier)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("want %v; got %v", tt.want, err)
+			}
+		})
+	}
+
+	t.Run("Wrong code verifier", func(t *testing.T) {
+		verifier, _ := Random()
+		nonce, _ := Random()
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(p.AuthCodeURL("https://snippetbox.test/callback", "state", nonce, verifier))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		loc, _ := url.Parse(resp.Header.Get("Location"))
+
+		_, err = p.Exchange(context.Background(), "https://snippetbox.test/callback", loc.Query().Get("code"), "not-the-verifier", nonce)
+		if !errors.Is(err, ErrExchange) {
+			t.Errorf("want %v; got %v", ErrExchange, err)
+		}
+	})
+
+	t.Run("Bad signature", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forger := &mockIdP{key: other}
+		token := forger.sign(t, map[string]interface{}{
+			"iss": idp.URL, "sub": "user-1", "aud": "client", "nonce": "n",
+			"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+		})
+
+		_, err = p.Verify(context.Background(), token, "n")
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("want %v; got %v", ErrInvalidToken, err)
+		}
+	})
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.Close()
+
+	_, err := Discover(context.Background(), idp.URL+"/other", "client", "secret")
+	if err == nil {
+		t.Error("want error; got nil")
+	}
+}
@@ -0,0 +1,115 @@
+// Package totp implements the time-based one-time passwords of RFC 6238, as
+// used by authenticator apps: 6 digit codes derived with HMAC-SHA1 from a
+// shared secret and the number of 30 second periods since the Unix epoch.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is the length of time each code is valid for.
+	Period = 30 * time.Second
+
+	// Digits is the number of digits in each code.
+	Digits = 6
+)
+
+// encoding is the unpadded base32 encoding which authenticator apps expect
+// secrets in.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160-bit secret, base32 encoded.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URL returns the otpauth:// URL which authenticator apps read from a QR
+// code to add an account.
+func URL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("algorithm", "SHA1")
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Counter returns the number of periods between the Unix epoch and t.
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// hotp computes the HOTP value of RFC 4226 for the counter.
+func hotp(key []byte, counter int64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low 4 bits of the last byte pick the offset of
+	// the 31-bit value used for the code.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// Code returns the code for the secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Counter(t), Digits), nil
+}
+
+// Verify checks a code against the secret at time t, allowing for the
+// clocks being up to skew periods apart. It returns the counter the code
+// was valid for, so that callers can refuse to accept the same code twice.
+func Verify(secret, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Counter(t)
+	for i := -skew; i <= skew; i++ {
+		counter := now + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, counter, Digits)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
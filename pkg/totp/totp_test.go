@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// The SHA-1 test vectors from appendix B of RFC 6238.
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{name: "1970", unix: 59, want: "94287082"},
+		{name: "2005", unix: 1111111109, want: "07081804"},
+		{name: "2005b", unix: 1111111111, want: "14050471"},
+		{name: "2009", unix: 1234567890, want: "89005924"},
+		{name: "2033", unix: 2000000000, want: "69279037"},
+		{name: "2603", unix: 20000000000, want: "65353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hotp(key, Counter(time.Unix(tt.unix, 0)), 8)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		at     time.Time
+		wantOK bool
+	}{
+		{name: "Now", code: code, at: now, wantOK: true},
+		{name: "Previous period", code: code, at: now.Add(Period), wantOK: true},
+		{name: "Too late", code: code, at: now.Add(2 * Period), wantOK: false},
+		{name: "Spaces", code: code[:3] + " " + code[3:], at: now, wantOK: true},
+		{name: "Short", code: code[:5], at: now, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Verify(secret, tt.code, tt.at, 1)
+			if ok != tt.wantOK {
+				t.Fatalf("want ok %t; got %t", tt.wantOK, ok)
+			}
+			if ok && counter != Counter(now) {
+				t.Errorf("want counter %d; got %d", Counter(now), counter)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+// Package webauthn implements the server side of Web Authentication
+// (passkeys): checking the responses to navigator.credentials.create() when
+// a credential is registered, and to navigator.credentials.get() when it is
+// used to log in.
+//
+// Passkeys stand in for both a password and a second factor, so the user
+// must have been verified by the authenticator, with a PIN or biometric,
+// and not just be present.
+//
+// Only what the application needs is supported. Attestation is not
+// requested, so attestation statements are not verified, and public keys
+// must be ES256 (ECDSA P-256) or RS256 (RSA PKCS#1 v1.5), which between them
+// cover the authenticators in common use.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Flags in the authenticator data.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// COSE key parameters and algorithms (RFC 8152).
+const (
+	coseKeyType  = 1
+	coseAlg      = 3
+	coseCurve    = -1
+	coseX        = -2
+	coseY        = -3
+	coseRSAN     = -1
+	coseRSAE     = -2
+	coseKtyEC2   = 2
+	coseKtyRSA   = 3
+	coseAlgES256 = -7
+	coseAlgRS256 = -257
+	coseCrvP256  = 1
+)
+
+// Algorithms lists the COSE identifiers of the supported public key
+// algorithms, in order of preference, for use in pubKeyCredParams.
+var Algorithms = []int{coseAlgES256, coseAlgRS256}
+
+var (
+	ErrInvalidResponse = errors.New("webauthn: invalid response")
+	ErrVerification    = errors.New("webauthn: verification failed")
+	ErrUnsupportedKey  = errors.New("webauthn: unsupported public key")
+	ErrClonedKey       = errors.New("webauthn: signature counter went backwards")
+)
+
+// Encoding is the base64url encoding without padding that WebAuthn uses for
+// binary values in JSON.
+var Encoding = base64.RawURLEncoding
+
+// A RelyingParty is the site credentials are registered with. ID is the
+// domain (like "example.com") and Origin the full origin the pages are
+// served from (like "https://example.com").
+type RelyingParty struct {
+	ID     string
+	Origin string
+}
+
+// A Credential is a registered public key.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE encoded
+	SignCount uint32
+}
+
+// NewChallenge returns 32 random bytes to be signed by the authenticator.
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// checkClientData checks that the client data is for the expected ceremony,
+// challenge and origin.
+func (rp *RelyingParty) checkClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ErrInvalidResponse
+	}
+
+	got, err := Encoding.DecodeString(cd.Challenge)
+	if err != nil {
+		return ErrInvalidResponse
+	}
+
+	if cd.Type != typ || cd.Origin != rp.Origin || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrVerification
+	}
+	return nil
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData splits the authenticator data into its fields,
+// including the attested credential data when the AT flag is set.
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, ErrInvalidResponse
+	}
+
+	ad := &authenticatorData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if ad.flags&flagAttested == 0 {
+		return ad, nil
+	}
+
+	// Attested credential data: a 16 byte AAGUID, the length of the
+	// credential ID and the ID itself, then the CBOR encoded public key.
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidResponse
+	}
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < n {
+		return nil, ErrInvalidResponse
+	}
+	ad.credentialID = rest[:n]
+
+	var key cbor.RawMessage
+	if err := cbor.NewDecoder(bytes.NewReader(rest[n:])).Decode(&key); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	ad.publicKey = key
+
+	return ad, nil
+}
+
+// checkAuthenticatorData checks that the data is for this relying party and
+// that the user was present and verified.
+func (rp *RelyingParty) checkAuthenticatorData(ad *authenticatorData) error {
+	want := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(ad.rpIDHash, want[:]) != 1 {
+		return ErrVerification
+	}
+	if ad.flags&flagUserPresent == 0 || ad.flags&flagUserVerified == 0 {
+		return ErrVerification
+	}
+	return nil
+}
+
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+// VerifyRegistration checks the response to navigator.credentials.create()
+// against the challenge which was sent, and returns the new credential.
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestation []byte) (*Credential, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	var obj attestationObject
+	if err := cbor.Unmarshal(attestation, &obj); err != nil {
+		return nil, ErrInvalidResponse
+	}
+
+	ad, err := parseAuthenticatorData(obj.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.checkAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+	if ad.flags&flagAttested == 0 || len(ad.credentialID) == 0 {
+		return nil, ErrInvalidResponse
+	}
+
+	// Make sure the key is one we'll be able to verify signatures with.
+	if _, _, err := parsePublicKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.publicKey,
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response to navigator.credentials.get() for
+// the credential against the challenge which was sent, and returns the
+// authenticator's new signature counter, which should be stored. A counter
+// which hasn't increased suggests the authenticator has been cloned, and is
+// reported as ErrClonedKey.
+func (rp *RelyingParty) VerifyAssertion(cred *Credential, challenge, clientDataJSON, authData, signature []byte) (uint32, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.checkAuthenticatorData(ad); err != nil {
+		return 0, err
+	}
+
+	// The signature covers the authenticator data followed by the hash of
+	// the client data.
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	if err := verifySignature(cred.PublicKey, signed, signature); err != nil {
+		return 0, err
+	}
+
+	// Authenticators which don't keep a counter always report zero.
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, ErrClonedKey
+	}
+
+	return ad.signCount, nil
+}
+
+// parsePublicKey decodes a COSE public key, returning the key and the hash
+// function its algorithm signs with.
+func parsePublicKey(cose []byte) (crypto.PublicKey, int, error) {
+	var m map[int]interface{}
+	if err := cbor.Unmarshal(cose, &m); err != nil {
+		return nil, 0, ErrInvalidResponse
+	}
+
+	kty, _ := m[coseKeyType].(uint64)
+	alg, _ := m[coseAlg].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == coseAlgES256:
+		crv, _ := m[coseCurve].(uint64)
+		x, _ := m[coseX].([]byte)
+		y, _ := m[coseY].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, ErrUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, ErrUnsupportedKey
+		}
+		return key, coseAlgES256, nil
+
+	case kty == coseKtyRSA && alg == coseAlgRS256:
+		n, _ := m[coseRSAN].([]byte)
+		e, _ := m[coseRSAE].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, ErrUnsupportedKey
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, coseAlgRS256, nil
+	}
+
+	return nil, 0, ErrUnsupportedKey
+}
+
+// verifySignature checks a signature made with the COSE public key.
+func verifySignature(cose, signed, signature []byte) error {
+	key, alg, err := parsePublicKey(cose)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case coseAlgES256:
+		if !ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), digest[:], signature) {
+			return ErrVerification
+		}
+	case coseAlgRS256:
+		if err := rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+			return ErrVerification
+		}
+	default:
+		return fmt.Errorf("webauthn: unexpected algorithm %d", alg)
+	}
+	return nil
+}
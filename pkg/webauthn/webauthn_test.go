@@ -0,0 +1,183 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// softAuthenticator is an in-memory authenticator holding a single P-256
+// key, standing in for a browser and security key in the tests.
+type softAuthenticator struct {
+	t         *testing.T
+	key       *ecdsa.PrivateKey
+	credID    []byte
+	signCount uint32
+	noUV      bool // only check the user is present, like a basic security key
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{t: t, key: key, credID: []byte("soft-credential-1")}
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte, origin string) []byte {
+	b, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": Encoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return b
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte, attested []byte) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	b := append([]byte{}, hash[:]...)
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, a.signCount)
+	return append(b, attested...)
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	b, err := cbor.Marshal(map[int]interface{}{
+		coseKeyType: coseKtyEC2,
+		coseAlg:     coseAlgES256,
+		coseCurve:   coseCrvP256,
+		coseX:       a.key.X.FillBytes(make([]byte, 32)),
+		coseY:       a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return b
+}
+
+// create answers navigator.credentials.create() with "none" attestation.
+func (a *softAuthenticator) create(rpID, origin string, challenge []byte) (clientDataJSON, attestation []byte) {
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credID)))
+	attested = append(attested, a.credID...)
+	attested = append(attested, a.coseKey()...)
+
+	obj, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(rpID, flagUserPresent|flagUserVerified|flagAttested, attested),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return a.clientData("webauthn.create", challenge, origin), obj
+}
+
+// get answers navigator.credentials.get(), bumping the signature counter.
+func (a *softAuthenticator) get(rpID, origin string, challenge []byte) (clientDataJSON, authData, signature []byte) {
+	a.signCount++
+	clientDataJSON = a.clientData("webauthn.get", challenge, origin)
+	flags := byte(flagUserPresent | flagUserVerified)
+	if a.noUV {
+		flags = flagUserPresent
+	}
+	authData = a.authData(rpID, flags, nil)
+
+	hash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), hash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return clientDataJSON, authData, signature
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	rp := &RelyingParty{ID: "example.com", Origin: "https://example.com"}
+	auth := newSoftAuthenticator(t)
+
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDataJSON, attestation := auth.create(rp.ID, rp.Origin, challenge)
+
+	cred, err := rp.VerifyRegistration(challenge, clientDataJSON, attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cred.ID) != string(auth.credID) {
+		t.Errorf("want credential ID %q; got %q", auth.credID, cred.ID)
+	}
+
+	// Logging in with the registered credential should succeed and return
+	// the new counter.
+	challenge, _ = NewChallenge()
+	clientDataJSON, authData, signature := auth.get(rp.ID, rp.Origin, challenge)
+	count, err := rp.VerifyAssertion(cred, challenge, clientDataJSON, authData, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("want sign count 1; got %d", count)
+	}
+	cred.SignCount = count
+
+	// Replaying the same assertion must fail, both because the counter
+	// hasn't moved on and because the challenge will have changed.
+	if _, err := rp.VerifyAssertion(cred, challenge, clientDataJSON, authData, signature); err != ErrClonedKey {
+		t.Errorf("want ErrClonedKey for a replayed assertion; got %v", err)
+	}
+	other, _ := NewChallenge()
+	if _, err := rp.VerifyAssertion(cred, other, clientDataJSON, authData, signature); err != ErrVerification {
+		t.Errorf("want ErrVerification for the wrong challenge; got %v", err)
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	rp := &RelyingParty{ID: "example.com", Origin: "https://example.com"}
+	auth := newSoftAuthenticator(t)
+
+	challenge, _ := NewChallenge()
+	clientDataJSON, attestation := auth.create(rp.ID, rp.Origin, challenge)
+	cred, err := rp.VerifyRegistration(challenge, clientDataJSON, attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		rpID   string
+		origin string
+		tamper bool
+		noUV   bool
+	}{
+		{name: "Wrong origin", rpID: rp.ID, origin: "https://evil.example"},
+		{name: "Wrong RP ID", rpID: "evil.example", origin: rp.Origin},
+		{name: "Bad signature", rpID: rp.ID, origin: rp.Origin, tamper: true},
+		{name: "User not verified", rpID: rp.ID, origin: rp.Origin, noUV: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth.noUV = tt.noUV
+			challenge, _ := NewChallenge()
+			clientDataJSON, authData, signature := auth.get(tt.rpID, tt.origin, challenge)
+			if tt.tamper {
+				signature[len(signature)-1] ^= 0xff
+			}
+			if _, err := rp.VerifyAssertion(cred, challenge, clientDataJSON, authData, signature); err == nil {
+				t.Error("want an error; got nil")
+			}
+		})
+	}
+}
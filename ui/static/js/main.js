@@ -5,4 +5,163 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+// Highlight the lines named in the URL fragment (like #L10 or #L10-L20) and
+// let shift-clicking a line number extend the selection to a range.
+var codeLines = document.querySelectorAll(".snippet .line");
+if (codeLines.length > 0) {
+	var parseRange = function(hash) {
+		var m = /^#L(\d+)(?:-L(\d+))?$/.exec(hash);
+		if (!m) {
+			return null;
+		}
+		var start = parseInt(m[1], 10);
+		var end = m[2] ? parseInt(m[2], 10) : start;
+		return start <= end ? [start, end] : [end, start];
+	};
+
+	var highlight = function() {
+		var range = parseRange(window.location.hash);
+		if (!range) {
+			return;
+		}
+		for (var i = 0; i < codeLines.length; i++) {
+			var n = i + 1;
+			codeLines[i].classList.toggle("highlight", n >= range[0] && n <= range[1]);
+		}
+		var first = document.getElementById("L" + range[0]);
+		if (first) {
+			first.scrollIntoView();
+		}
+	};
+
+	var numbers = document.querySelectorAll(".snippet .line .number");
+	for (var i = 0; i < numbers.length; i++) {
+		numbers[i].addEventListener("click", function(e) {
+			var line = parseInt(this.getAttribute("data-line"), 10);
+			var current = parseRange(window.location.hash);
+			if (e.shiftKey && current) {
+				e.preventDefault();
+				var start = Math.min(current[0], line);
+				var end = Math.max(current[0], line);
+				history.replaceState(null, "", start == end ? "#L" + start : "#L" + start + "-L" + end);
+				highlight();
+			}
+		});
+	}
+
+	window.addEventListener("hashchange", highlight);
+	highlight();
+}
+
+// Passkeys. The server sends and expects binary values as base64url
+// strings, so convert them on the way in and out of the WebAuthn API.
+var fromBase64url = function(s) {
+	s = s.replace(/-/g, "+").replace(/_/g, "/");
+	while (s.length % 4) {
+		s += "=";
+	}
+	var bin = atob(s);
+	var bytes = new Uint8Array(bin.length);
+	for (var i = 0; i < bin.length; i++) {
+		bytes[i] = bin.charCodeAt(i);
+	}
+	return bytes.buffer;
+};
+
+var toBase64url = function(buf) {
+	var bytes = new Uint8Array(buf);
+	var bin = "";
+	for (var i = 0; i < bytes.length; i++) {
+		bin += String.fromCharCode(bytes[i]);
+	}
+	return btoa(bin).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+};
+
+var postJSON = function(form, url, body) {
+	return fetch(url, {
+		method: "POST",
+		credentials: "same-origin",
+		headers: {
+			"Content-Type": "application/json",
+			"X-CSRF-Token": form.dataset.csrfToken
+		},
+		body: JSON.stringify(body)
+	}).then(function(resp) {
+		return resp.json().catch(function() {
+			return {};
+		}).then(function(data) {
+			if (!resp.ok) {
+				throw new Error(data.error || "Something went wrong. Please try again.");
+			}
+			return data;
+		});
+	});
+};
+
+var passkeyError = function(form, err) {
+	var el = form.querySelector(".error");
+	el.textContent = err.message;
+	el.hidden = false;
+};
+
+var allowList = function(list) {
+	return list.map(function(c) {
+		return {type: c.type, id: fromBase64url(c.id)};
+	});
+};
+
+var registerForm = document.getElementById("passkey-register");
+if (registerForm && window.PublicKeyCredential) {
+	registerForm.addEventListener("submit", function(e) {
+		e.preventDefault();
+		postJSON(registerForm, "/user/webauthn/register/begin", {}).then(function(opts) {
+			opts.challenge = fromBase64url(opts.challenge);
+			opts.user.id = fromBase64url(opts.user.id);
+			opts.excludeCredentials = allowList(opts.excludeCredentials);
+			return navigator.credentials.create({publicKey: opts});
+		}).then(function(cred) {
+			return postJSON(registerForm, "/user/webauthn/register/finish", {
+				id: cred.id,
+				name: registerForm.elements.name.value,
+				response: {
+					clientDataJSON: toBase64url(cred.response.clientDataJSON),
+					attestationObject: toBase64url(cred.response.attestationObject)
+				}
+			});
+		}).then(function(data) {
+			window.location = data.redirect;
+		}).catch(function(err) {
+			passkeyError(registerForm, err);
+		});
+	});
+}
+
+var passkeyLogin = document.getElementById("passkey-login");
+if (passkeyLogin) {
+	if (!window.PublicKeyCredential) {
+		passkeyLogin.hidden = true;
+	}
+	passkeyLogin.addEventListener("submit", function(e) {
+		e.preventDefault();
+		postJSON(passkeyLogin, "/user/login/webauthn/begin", {}).then(function(opts) {
+			opts.challenge = fromBase64url(opts.challenge);
+			opts.allowCredentials = allowList(opts.allowCredentials);
+			return navigator.credentials.get({publicKey: opts});
+		}).then(function(cred) {
+			return postJSON(passkeyLogin, "/user/login/webauthn/finish", {
+				id: cred.id,
+				response: {
+					clientDataJSON: toBase64url(cred.response.clientDataJSON),
+					authenticatorData: toBase64url(cred.response.authenticatorData),
+					signature: toBase64url(cred.response.signature),
+					userHandle: cred.response.userHandle ? toBase64url(cred.response.userHandle) : ""
+				}
+			});
+		}).then(function(data) {
+			window.location = data.redirect;
+		}).catch(function(err) {
+			passkeyError(passkeyLogin, err);
+		});
+	});
+}